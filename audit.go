@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+// auditFile is the audit log file. It can be reopened after logrotate has
+// moved it.
+type auditFile struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+func openAuditFile(path string) (*auditFile, error) {
+	a := &auditFile{}
+	if err := a.reopen(path); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *auditFile) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Write(p)
+}
+
+// reopen closes the file and opens path for appending, creating it if needed.
+// The current file is kept if path can't be opened.
+func (a *auditFile) reopen(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f != nil {
+		a.f.Close()
+	}
+	a.path, a.f = path, f
+	return nil
+}
+
+func (a *auditFile) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
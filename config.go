@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lfkeitel/tftp-go/tftp"
+	"gopkg.in/yaml.v3"
+)
+
+// config holds the server settings. It's loaded from the config file and then
+// overridden by any flags given on the command line, keys match the flag names.
+type config struct {
+	Listen          stringList    `yaml:"listen"`
+	Root            string        `yaml:"root"`
+	DisableCreate   bool          `yaml:"nocreate"`
+	DisableWrite    bool          `yaml:"nowrite"`
+	AllowOverwrite  bool          `yaml:"ow"`
+	Symlinks        string        `yaml:"symlinks"`
+	Paths           []pathConfig  `yaml:"paths"`
+	ReadACL         stringList    `yaml:"read-acl"`
+	WriteACL        stringList    `yaml:"write-acl"`
+	RateLimit       int64         `yaml:"ratelimit"`
+	GlobalLimit     int64         `yaml:"global-ratelimit"`
+	NetLimits       stringList    `yaml:"net-ratelimit"`
+	MaxTransfers    int           `yaml:"max-transfers"`
+	MaxPerClient    int           `yaml:"max-client-transfers"`
+	QueueTimeout    time.Duration `yaml:"queue-timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown-timeout"`
+	Debug           bool          `yaml:"debug"`
+	RFC1350         bool          `yaml:"rfc1350"`
+	Strict          bool          `yaml:"strict"`
+	Rollover        int           `yaml:"rollover"`
+	Retries         int           `yaml:"retries"`
+	AdaptiveTimeout bool          `yaml:"adaptive-timeout"`
+	MaxTimeout      time.Duration `yaml:"max-timeout"`
+	Ports           string        `yaml:"ports"`
+	SinglePort      bool          `yaml:"single-port"`
+	Metrics         string        `yaml:"metrics"`
+	AuditLog        string        `yaml:"audit-log"`
+	LogFormat       string        `yaml:"log-format"`
+	OnStart         string        `yaml:"on-start"`
+	OnComplete      string        `yaml:"on-complete"`
+	OnFail          string        `yaml:"on-fail"`
+}
+
+// pathConfig sets the permissions for a directory, replacing the server-wide
+// nocreate, nowrite and ow settings for everything below it.
+type pathConfig struct {
+	Path           string `yaml:"path"`
+	DisableRead    bool   `yaml:"noread"`
+	DisableWrite   bool   `yaml:"nowrite"`
+	DisableCreate  bool   `yaml:"nocreate"`
+	AllowOverwrite bool   `yaml:"ow"`
+}
+
+func defaultConfig() *config {
+	return &config{
+		Root:            ".",
+		Symlinks:        "root",
+		ShutdownTimeout: 30 * time.Second,
+		Rollover:        -1,
+		Retries:         5,
+		MaxTimeout:      30 * time.Second,
+		LogFormat:       "text",
+	}
+}
+
+// load reads the YAML config file at path into c. Unknown keys are an error.
+func (c *config) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dec := yaml.NewDecoder(file)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// listen returns the addresses to listen on, all addresses on the TFTP port if
+// none are set.
+func (c *config) listen() []string {
+	if len(c.Listen) == 0 {
+		return []string{fmt.Sprintf(":%d", tftp.DefaultPort)}
+	}
+	return c.Listen
+}
+
+// serverOptions validates the config and returns the matching server options.
+func (c *config) serverOptions() ([]tftp.ServerOption, error) {
+	if c.AllowOverwrite && c.DisableWrite {
+		return nil, errors.New("nowrite cannot be used with ow")
+	}
+	for _, address := range c.listen() {
+		if _, err := net.ResolveUDPAddr("udp", address); err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %w", address, err)
+		}
+	}
+	if c.Metrics != "" {
+		if _, err := net.ResolveTCPAddr("tcp", c.Metrics); err != nil {
+			return nil, fmt.Errorf("invalid metrics address %q: %w", c.Metrics, err)
+		}
+	}
+	if c.Rollover < -1 || c.Rollover > 1 {
+		return nil, fmt.Errorf("invalid rollover %d, expected 0 or 1", c.Rollover)
+	}
+	if c.Retries < 0 {
+		return nil, fmt.Errorf("invalid retries %d", c.Retries)
+	}
+	if c.MaxTimeout <= 0 {
+		return nil, fmt.Errorf("invalid max-timeout %s", c.MaxTimeout)
+	}
+
+	stat, err := os.Stat(c.Root)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		return nil, fmt.Errorf("root %s is not a directory", c.Root)
+	}
+
+	symlinks, err := tftp.ParseSymlinkPolicy(c.Symlinks)
+	if err != nil {
+		return nil, err
+	}
+
+	logger, err := c.logger()
+	if err != nil {
+		return nil, err
+	}
+
+	options := []tftp.ServerOption{
+		tftp.WithRootDir(c.Root, tftp.WithSymlinkPolicy(symlinks)),
+		tftp.WithLogger(logger),
+		tftp.WithHooks(c.execHooks()),
+	}
+	if c.DisableCreate {
+		options = append(options, tftp.WithDisableCreate)
+	}
+	if c.DisableWrite {
+		options = append(options, tftp.WithDisableWrite)
+	}
+	if c.AllowOverwrite {
+		options = append(options, tftp.WithAllowOverwrite)
+	}
+	if c.Strict {
+		options = append(options, tftp.WithStrict)
+	}
+	if c.RFC1350 {
+		options = append(options, tftp.WithRFC1350)
+	}
+	if c.Rollover > -1 {
+		options = append(options, tftp.WithRollover(c.Rollover))
+	}
+
+	for _, path := range c.Paths {
+		if path.Path == "" {
+			return nil, errors.New("path rule without a path")
+		}
+		if path.AllowOverwrite && path.DisableWrite {
+			return nil, fmt.Errorf("path %s: nowrite cannot be used with ow", path.Path)
+		}
+		options = append(options, tftp.WithPathRule(tftp.PathRule{
+			Prefix: path.Path,
+			Permissions: tftp.Permissions{
+				DisableRead:    path.DisableRead,
+				DisableWrite:   path.DisableWrite,
+				DisableCreate:  path.DisableCreate,
+				AllowOverwrite: path.AllowOverwrite,
+			},
+		}))
+	}
+
+	for _, rule := range c.ReadACL {
+		r, err := tftp.ParseACLRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, tftp.WithReadACL(r))
+	}
+	for _, rule := range c.WriteACL {
+		r, err := tftp.ParseACLRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, tftp.WithWriteACL(r))
+	}
+
+	if c.RateLimit > 0 {
+		options = append(options, tftp.WithRateLimit(c.RateLimit))
+	}
+	if c.GlobalLimit > 0 {
+		options = append(options, tftp.WithGlobalRateLimit(c.GlobalLimit))
+	}
+	for _, limit := range c.NetLimits {
+		network, rate, err := parseNetworkRateLimit(limit)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, tftp.WithNetworkRateLimit(network, rate))
+	}
+
+	if c.SinglePort && c.Ports != "" {
+		return nil, errors.New("single-port cannot be used with ports")
+	}
+	if c.SinglePort {
+		options = append(options, tftp.WithSinglePort)
+	}
+	if c.Ports != "" {
+		low, high, err := parsePortRange(c.Ports)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, tftp.WithPortRange(low, high))
+	}
+
+	if c.AdaptiveTimeout {
+		options = append(options, tftp.WithAdaptiveTimeout)
+	}
+
+	options = append(options,
+		tftp.WithRetries(c.Retries),
+		tftp.WithMaxTimeout(c.MaxTimeout),
+		tftp.WithMaxTransfers(c.MaxTransfers),
+		tftp.WithMaxClientTransfers(c.MaxPerClient),
+		tftp.WithQueueTimeout(c.QueueTimeout),
+		tftp.WithShutdownTimeout(c.ShutdownTimeout),
+	)
+	return options, nil
+}
+
+// logger returns a logger writing to stderr in the configured format, debug
+// messages are included if debug is set.
+func (c *config) logger() (*slog.Logger, error) {
+	options := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if c.Debug {
+		options.Level = slog.LevelDebug
+	}
+
+	switch c.LogFormat {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, options)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, expected text or json", c.LogFormat)
+}
+
+// parseNetworkRateLimit parses a "CIDR BYTES" network rate limit.
+func parseNetworkRateLimit(s string) (*net.IPNet, int64, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return nil, 0, fmt.Errorf("invalid network rate limit %q", s)
+	}
+
+	_, network, err := net.ParseCIDR(fields[0])
+	if err != nil {
+		return nil, 0, err
+	}
+	rate, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid network rate limit %q: %w", s, err)
+	}
+	return network, rate, nil
+}
+
+// parsePortRange parses a "LOW-HIGH" port range, a single port is a range of
+// one.
+func parsePortRange(s string) (int, int, error) {
+	lowPort, highPort, ok := strings.Cut(s, "-")
+	if !ok {
+		highPort = lowPort
+	}
+
+	low, err := strconv.ParseUint(strings.TrimSpace(lowPort), 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	high, err := strconv.ParseUint(strings.TrimSpace(highPort), 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	if low == 0 || low > high {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return int(low), int(high), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "tftp.yml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigLoad(t *testing.T) {
+	path := writeConfig(t, `
+listen: ["127.0.0.1:6969"]
+root: /srv/tftp
+nowrite: true
+paths:
+  - path: uploads
+    ow: true
+read-acl:
+  - allow any boot/
+queue-timeout: 2s
+`)
+
+	c := defaultConfig()
+	if err := c.load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Listen) != 1 || c.Listen[0] != "127.0.0.1:6969" {
+		t.Errorf("expected listen address from file, got %v", c.Listen)
+	}
+	if c.Root != "/srv/tftp" || !c.DisableWrite {
+		t.Errorf("expected root and nowrite from file, got %q and %t", c.Root, c.DisableWrite)
+	}
+	if len(c.Paths) != 1 || c.Paths[0].Path != "uploads" || !c.Paths[0].AllowOverwrite {
+		t.Errorf("unexpected path rules %+v", c.Paths)
+	}
+	if len(c.ReadACL) != 1 || c.QueueTimeout != 2*time.Second {
+		t.Errorf("unexpected read-acl %v or queue-timeout %s", c.ReadACL, c.QueueTimeout)
+	}
+	if c.Rollover != -1 || c.ShutdownTimeout != 30*time.Second {
+		t.Errorf("defaults not kept, got rollover %d and shutdown-timeout %s", c.Rollover, c.ShutdownTimeout)
+	}
+}
+
+func TestConfigListen(t *testing.T) {
+	c := defaultConfig()
+	if listen := c.listen(); len(listen) != 1 || listen[0] != ":69" {
+		t.Errorf("expected to listen on :69 by default, got %v", listen)
+	}
+
+	c.Root = t.TempDir()
+	c.Listen = stringList{"127.0.0.1:6969", "[::1]:6969"}
+	if _, err := c.serverOptions(); err != nil {
+		t.Fatal(err)
+	}
+	if listen := c.listen(); len(listen) != 2 {
+		t.Errorf("expected the configured addresses, got %v", listen)
+	}
+}
+
+func TestConfigUnknownKey(t *testing.T) {
+	path := writeConfig(t, "nowrtie: true\n")
+	if err := defaultConfig().load(path); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := map[string]func(c *config){
+		"nowrite with ow":       func(c *config) { c.DisableWrite, c.AllowOverwrite = true, true },
+		"missing root":          func(c *config) { c.Root = filepath.Join(c.Root, "missing") },
+		"bad listen":            func(c *config) { c.Listen = []string{"localhost"} },
+		"bad symlinks":          func(c *config) { c.Symlinks = "sometimes" },
+		"bad rollover":          func(c *config) { c.Rollover = 2 },
+		"bad acl":               func(c *config) { c.WriteACL = stringList{"permit any"} },
+		"bad log format":        func(c *config) { c.LogFormat = "xml" },
+		"bad ports":             func(c *config) { c.Ports = "50999-50000" },
+		"port zero":             func(c *config) { c.Ports = "0-100" },
+		"single-port and ports": func(c *config) { c.SinglePort, c.Ports = true, "50000-50999" },
+		"negative retries":      func(c *config) { c.Retries = -1 },
+		"zero max-timeout":      func(c *config) { c.MaxTimeout = 0 },
+		"bad net-ratelimit":     func(c *config) { c.NetLimits = stringList{"10.0.0.0/8"} },
+		"path without path":     func(c *config) { c.Paths = []pathConfig{{DisableWrite: true}} },
+		"path nowrite and ow":   func(c *config) { c.Paths = []pathConfig{{Path: "a", DisableWrite: true, AllowOverwrite: true}} },
+	}
+
+	for name, modify := range tests {
+		c := defaultConfig()
+		c.Root = t.TempDir()
+		modify(c)
+		if _, err := c.serverOptions(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	c := defaultConfig()
+	c.Root = t.TempDir()
+	if _, err := c.serverOptions(); err != nil {
+		t.Errorf("default config: %s", err)
+	}
+}
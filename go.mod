@@ -1,3 +1,5 @@
 module github.com/lfkeitel/tftp-go
 
-go 1.17
+go 1.21
+
+require gopkg.in/yaml.v3 v3.0.1
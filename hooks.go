@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/lfkeitel/tftp-go/tftp"
+)
+
+// execHooks returns hooks running the configured commands. Commands run in the
+// background with the transfer described in TFTP_* environment variables.
+func (c *config) execHooks() tftp.Hooks {
+	var hooks tftp.Hooks
+	if c.OnStart != "" {
+		hooks.Start = execHook(c.OnStart, "start")
+	}
+	if c.OnComplete != "" {
+		hooks.Complete = execHook(c.OnComplete, "complete")
+	}
+	if c.OnFail != "" {
+		hooks.Fail = execHook(c.OnFail, "fail")
+	}
+	return hooks
+}
+
+func execHook(command, event string) func(tftp.Transfer) {
+	return func(t tftp.Transfer) {
+		cmd := exec.Command("/bin/sh", "-c", command)
+		cmd.Env = append(os.Environ(),
+			"TFTP_EVENT="+event,
+			"TFTP_TRANSFER="+strconv.FormatUint(t.ID, 10),
+			"TFTP_PEER="+t.Peer.String(),
+			"TFTP_FILE="+t.Filename,
+			"TFTP_OP="+t.Op.String(),
+			"TFTP_BYTES="+strconv.FormatInt(t.Bytes, 10),
+			"TFTP_DURATION="+strconv.FormatFloat(t.Duration.Seconds(), 'f', 3, 64),
+		)
+		if t.Err != nil {
+			cmd.Env = append(cmd.Env, "TFTP_ERROR="+t.Err.Error())
+		}
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+
+		go func() {
+			if err := cmd.Run(); err != nil {
+				slog.Error("Hook command failed", "event", event, "transfer", t.ID, "error", err)
+			}
+		}()
+	}
+}
@@ -1,194 +1,368 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+
+	"github.com/lfkeitel/tftp-go/tftp"
 )
 
 var (
-	flgRootDir        string
-	flgDisableCreate  bool
-	flgDisableWrite   bool
-	flgAllowOverwrite bool
-	flgServer         bool
-	flgDebug          bool
-	flgRFC1350        bool
-	flgStrict         bool
+	cfg = defaultConfig()
+
+	flgConfig     string
+	flgServer     bool
+	flgWindowSize int
+	flgMode       string
 )
 
+// stringList is a flag that can be given multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
 func init() {
-	flag.StringVar(&flgRootDir, "root", ".", "Server root")
-	flag.BoolVar(&flgDisableCreate, "nocreate", false, "Disable creation of new files")
-	flag.BoolVar(&flgDisableWrite, "nowrite", false, "Disable writing any files")
-	flag.BoolVar(&flgAllowOverwrite, "ow", false, "Allow overwriting existing files")
+	flag.StringVar(&flgConfig, "config", "", "Server config file, flags override its settings")
+	flag.Var(&cfg.Listen, "listen", "Address to listen on, e.g. 192.0.2.1:69 or [2001:db8::1]:69, can be given multiple times")
+	flag.StringVar(&cfg.Root, "root", cfg.Root, "Server root")
+	flag.BoolVar(&cfg.DisableCreate, "nocreate", false, "Disable creation of new files")
+	flag.BoolVar(&cfg.DisableWrite, "nowrite", false, "Disable writing any files")
+	flag.BoolVar(&cfg.AllowOverwrite, "ow", false, "Allow overwriting existing files")
+	flag.StringVar(&cfg.Symlinks, "symlinks", cfg.Symlinks, "Symlink policy: root, never or always")
+	flag.Var(&cfg.ReadACL, "read-acl", "Read ACL rule \"allow|deny NETWORK [PREFIX]\", can be given multiple times")
+	flag.Var(&cfg.WriteACL, "write-acl", "Write ACL rule \"allow|deny NETWORK [PREFIX]\", can be given multiple times")
+	flag.Int64Var(&cfg.RateLimit, "ratelimit", 0, "Limit each transfer to bytes per second")
+	flag.Int64Var(&cfg.GlobalLimit, "global-ratelimit", 0, "Limit all transfers combined to bytes per second")
+	flag.Var(&cfg.NetLimits, "net-ratelimit", "Limit all transfers with clients in a network \"CIDR BYTES\", can be given multiple times")
+	flag.IntVar(&cfg.MaxTransfers, "max-transfers", 0, "Maximum concurrent transfers, 0 is unlimited")
+	flag.IntVar(&cfg.MaxPerClient, "max-client-transfers", 0, "Maximum concurrent transfers per client IP, 0 is unlimited")
+	flag.DurationVar(&cfg.QueueTimeout, "queue-timeout", 0, "How long requests over the transfer limits wait before being rejected")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "How long transfers may take to finish when the server is stopped")
+	flag.StringVar(&cfg.Ports, "ports", "", "UDP port range for transfer sockets, e.g. 50000-50999")
+	flag.BoolVar(&cfg.SinglePort, "single-port", false, "Run all transfers over the listening socket instead of a new port each")
+	flag.StringVar(&cfg.Metrics, "metrics", "", "Serve Prometheus metrics over HTTP on this address, e.g. :9169")
+	flag.StringVar(&cfg.AuditLog, "audit-log", "", "Append a JSON record of every request to this file, reopened on SIGHUP")
+	flag.StringVar(&cfg.OnStart, "on-start", "", "Shell command run when a transfer starts")
+	flag.StringVar(&cfg.OnComplete, "on-complete", "", "Shell command run when a transfer completes")
+	flag.StringVar(&cfg.OnFail, "on-fail", "", "Shell command run when a transfer fails")
 	flag.BoolVar(&flgServer, "server", false, "Run a TFTP server")
-	flag.BoolVar(&flgDebug, "debug", false, "Enable debug output")
-	flag.BoolVar(&flgRFC1350, "rfc1350", false, "Disable TFTP options")
-	flag.BoolVar(&flgStrict, "strict", false, "Reject clients wanting to use netascii or mail modes")
+	flag.BoolVar(&cfg.Debug, "debug", false, "Enable debug output")
+	flag.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "Log format, text or json")
+	flag.BoolVar(&cfg.RFC1350, "rfc1350", false, "Disable TFTP options")
+	flag.BoolVar(&cfg.Strict, "strict", false, "Reject clients wanting to use mail or unknown modes")
+	flag.StringVar(&flgMode, "mode", tftp.ModeOctet, "Client transfer mode, octet or netascii")
+	flag.IntVar(&cfg.Rollover, "rollover", cfg.Rollover, "Block number used after block 65535, 0 or 1")
+	flag.IntVar(&cfg.Retries, "retries", cfg.Retries, "Times a packet is sent again after timeouts before a transfer fails")
+	flag.BoolVar(&cfg.AdaptiveTimeout, "adaptive-timeout", false, "Derive retransmission timeouts from the measured round trip time")
+	flag.DurationVar(&cfg.MaxTimeout, "max-timeout", cfg.MaxTimeout, "Ceiling of the adaptive timeout as it backs off")
+	flag.IntVar(&flgWindowSize, "window", 1, "Number of blocks per window (RFC 7440) requested by the client")
 }
 
 func main() {
 	flag.Parse()
 
-	if flgAllowOverwrite && flgDisableWrite {
-		log.Fatalln("-nowrite cannot be used with -ow")
+	if flgConfig != "" {
+		if err := loadConfig(flgConfig); err != nil {
+			fatal(err)
+		}
+	}
+
+	logger, err := cfg.logger()
+	if err != nil {
+		fatal(err)
 	}
+	slog.SetDefault(logger)
 
 	if flgServer && flag.NArg() > 0 {
-		log.Fatalln("-server cannot be used with a command")
+		fatal(errors.New("-server cannot be used with a command"))
 	}
 
 	if flgServer {
 		startServer()
+	} else if flag.Arg(0) == "check" {
+		checkConfig()
 	} else {
 		runCommand(flag.Args())
 	}
 }
 
-func startServer() {
-	serverOptions := []serverOption{withRootDir(flgRootDir)}
-	if flgDisableCreate {
-		serverOptions = append(serverOptions, withDisableCreate)
-	}
-	if flgDisableWrite {
-		serverOptions = append(serverOptions, withDisableWrite)
-	}
-	if flgAllowOverwrite {
-		serverOptions = append(serverOptions, withAllowOverwrite)
+// loadConfig loads the config file into cfg, replacing all current settings.
+// The command line is parsed again afterwards so flags take precedence over the
+// file, list flags replace the list from the file instead of adding to it.
+func loadConfig(path string) error {
+	*cfg = *defaultConfig()
+	if err := cfg.load(path); err != nil {
+		return err
 	}
 
-	s := newServer(serverOptions...)
-	s.listenAndServe(fmt.Sprintf(":%d", tftpPort))
+	flag.Visit(func(f *flag.Flag) {
+		if list, ok := f.Value.(*stringList); ok {
+			*list = nil
+		}
+	})
+	return flag.CommandLine.Parse(os.Args[1:])
 }
 
-func runCommand(args []string) {
-	if len(args) != 3 {
+// checkConfig validates the configuration and exits.
+func checkConfig() {
+	if flag.NArg() != 1 {
 		printClientUsage()
 	}
-
-	remote := strings.SplitN(args[1], ":", 2)
-	if len(remote) != 2 {
-		printClientUsage()
+	if _, err := cfg.serverOptions(); err != nil {
+		fatal(err)
 	}
+	fmt.Println("Configuration OK")
+}
 
-	newConn, err := net.ListenPacket("udp", ":0")
+func startServer() {
+	serverOptions, err := cfg.serverOptions()
 	if err != nil {
-		log.Fatalln(err)
+		fatal(err)
 	}
 
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", remote[0], tftpPort))
-	if err != nil {
-		log.Fatalln(err)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var metrics *tftp.Metrics
+	if cfg.Metrics != "" {
+		metrics = tftp.NewMetrics()
+		serverOptions = append(serverOptions, tftp.WithMetrics(metrics))
+		if err := serveMetrics(ctx, cfg.Metrics, metrics); err != nil {
+			fatal(err)
+		}
 	}
-	directConn := &requestConn{conn: newConn, addr: addr}
 
-	switch args[0] {
-	case "put":
-		putFile(directConn, args[2], remote[1])
-	case "get":
-		getFile(directConn, remote[1], args[2])
-	default:
-		newConn.Close()
-		printClientUsage()
+	var audit *auditFile
+	if cfg.AuditLog != "" {
+		audit, err = openAuditFile(cfg.AuditLog)
+		if err != nil {
+			fatal(err)
+		}
+		defer audit.Close()
+		serverOptions = append(serverOptions, tftp.WithAuditLog(audit))
 	}
-}
 
-func putFile(conn *requestConn, source, dest string) {
-	file, err := os.Open(source)
-	if err != nil {
-		log.Fatalln(err)
+	s := tftp.NewServer(serverOptions...)
+
+	// Each address gets its own socket, stop all of them if one fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	listen := cfg.listen()
+	errs := make(chan error, len(listen))
+	for _, address := range listen {
+		go func(address string) {
+			errs <- s.ListenAndServe(ctx, address)
+		}(address)
 	}
 
-	opts := defaultOptions.copy()
+	// cfg is only touched by reloads from here on
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			reloadConfig(s, metrics, audit)
+		}
+	}()
 
-	debug("Sending write request")
-	if flgRFC1350 {
-		conn.sendWriteRequest(dest, modeOctet, nil)
-	} else {
-		tsize := filesize(file)
-		opts.blockSize = 1428
-		if tsize > -1 {
-			opts.tsize = tsize
+	var failed bool
+	for range listen {
+		if err := <-errs; err != nil {
+			slog.Error("Server failed", "error", err)
+			failed = true
+			cancel()
 		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+	slog.Info("Server stopped")
+}
 
-		conn.sendWriteRequest(dest, modeOctet, opts.toMap())
+// serveMetrics serves metrics on address at /metrics until ctx is cancelled.
+func serveMetrics(ctx context.Context, address string, metrics *tftp.Metrics) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
 	}
 
-	// Wait for server to ACK write request and/or options
-	retransmits := 0
-	for {
-		resp := conn.readNextMessage(opRead, defaultOptions)
-		if resp == nil || resp.op == opError {
-			conn.Close()
-			file.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	srv := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		slog.Info("Serving metrics", "url", fmt.Sprintf("http://%s/metrics", ln.Addr()))
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			slog.Error("Metrics server failed", "error", err)
+		}
+	}()
+	return nil
+}
+
+// reloadConfig reads the config file again and applies it to new requests.
+// The current configuration is kept if the new one is invalid. The audit log
+// is reopened either way so it can be rotated.
+func reloadConfig(s *tftp.Server, metrics *tftp.Metrics, audit *auditFile) {
+	defer func() {
+		if audit == nil {
 			return
 		}
+		if err := audit.reopen(cfg.AuditLog); err != nil {
+			slog.Error("Failed to reopen the audit log", "path", cfg.AuditLog, "error", err)
+		}
+	}()
 
-		if resp.op == opRetransmit {
-			if retransmits >= maxRetransmits {
-				conn.Close()
-				file.Close()
-				return
-			}
+	if flgConfig == "" {
+		slog.Warn("Received SIGHUP but no config file was given, nothing to reload")
+		return
+	}
 
-			debug("Retransmitting WRITE request")
-			conn.sendWriteRequest(dest, modeOctet, opts.toMap())
-			retransmits++
-			continue
-		} else if resp.op == opOAck {
-			debug("Received OACK")
-			opts = resp.options
-			break
-		} else if resp.op == opAck {
-			debug("Received ACK")
-			break
+	current := *cfg
+	err := loadConfig(flgConfig)
+	if err == nil {
+		var options []tftp.ServerOption
+		options, err = cfg.serverOptions()
+		if err == nil {
+			if metrics != nil {
+				options = append(options, tftp.WithMetrics(metrics))
+			}
+			if audit != nil {
+				options = append(options, tftp.WithAuditLog(audit))
+			}
+			err = s.Reload(options...)
 		}
 	}
+	if err != nil {
+		*cfg = current
+		slog.Error("Config reload failed, keeping the current configuration", "error", err)
+		return
+	}
 
-	remote := &client{
-		op:         opRead, // From the client we're reading a file to the server
-		conn:       conn,
-		data:       file,
-		options:    opts,
-		remotePath: dest,
+	if !equalStrings(cfg.listen(), current.listen()) {
+		slog.Warn("Listen addresses can't be changed without a restart, keeping the current addresses")
+		cfg.Listen = current.Listen
 	}
+	if cfg.Metrics != current.Metrics {
+		slog.Warn("The metrics address can't be changed without a restart, keeping the current address")
+		cfg.Metrics = current.Metrics
+	}
+	if (cfg.AuditLog == "") != (audit == nil) {
+		slog.Warn("The audit log can't be turned on or off without a restart")
+		cfg.AuditLog = current.AuditLog
+	}
+	if logger, err := cfg.logger(); err == nil {
+		slog.SetDefault(logger)
+	}
+	slog.Info("Configuration reloaded")
+}
 
-	remote.run()
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
-func getFile(conn *requestConn, source, dest string) {
-	file, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+func runCommand(args []string) {
+	if len(args) != 3 {
+		printClientUsage()
+	}
+
+	address, remotePath, err := parseRemote(args[1])
 	if err != nil {
-		log.Fatalln(err)
+		fatal(err)
 	}
 
-	opts := defaultOptions.copy()
+	clientOptions := []tftp.ClientOption{
+		tftp.WithClientWindowSize(flgWindowSize),
+		tftp.WithClientMode(flgMode),
+	}
+	if cfg.RFC1350 {
+		clientOptions = append(clientOptions, tftp.WithClientRFC1350)
+	}
+	if cfg.Rollover > -1 {
+		clientOptions = append(clientOptions, tftp.WithClientRollover(cfg.Rollover))
+	}
+	if cfg.RateLimit > 0 {
+		clientOptions = append(clientOptions, tftp.WithClientRateLimit(cfg.RateLimit))
+	}
+	if cfg.AdaptiveTimeout {
+		clientOptions = append(clientOptions, tftp.WithClientAdaptiveTimeout)
+	}
+	clientOptions = append(clientOptions,
+		tftp.WithClientRetries(cfg.Retries),
+		tftp.WithClientMaxTimeout(cfg.MaxTimeout),
+	)
 
-	debug("Sending read request")
-	if flgRFC1350 {
-		conn.sendReadRequest(source, modeOctet, nil)
-	} else {
-		opts.blockSize = 1428
-		conn.sendReadRequest(source, modeOctet, opts.toMap())
-		// The client will respond to OACKS and retransmit if needed.
+	client := tftp.NewClient(address, clientOptions...)
+
+	switch args[0] {
+	case "put":
+		err = putFile(client, args[2], remotePath)
+	case "get":
+		err = getFile(client, remotePath, args[2])
+	default:
+		printClientUsage()
+	}
+
+	if err != nil {
+		fatal(err)
 	}
+}
 
-	remote := &client{
-		op:               opWrite, // From the client we're writing to a file
-		conn:             conn,
-		data:             file,
-		options:          defaultOptions.copy(),
-		requestedOptions: opts,
-		remotePath:       source,
+func putFile(client *tftp.Client, source, dest string) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return err
 	}
+	defer file.Close()
+
+	return client.Put(dest, file)
+}
+
+func getFile(client *tftp.Client, source, dest string) error {
+	file, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return client.Get(source, file)
+}
 
-	remote.run()
+// fatal logs err and exits.
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
 }
 
 func printClientUsage() {
-	log.Fatalln("Usage: tftp [put|get] REMOTE:PATH LOCAL")
+	fmt.Fprintln(os.Stderr, "Usage: tftp [put|get] HOST[:PORT]:PATH LOCAL\n       tftp [put|get] tftp://HOST[:PORT]/PATH LOCAL\n       tftp -config FILE check")
+	os.Exit(2)
 }
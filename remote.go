@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/lfkeitel/tftp-go/tftp"
+)
+
+// parseRemote parses a remote file given to the client and returns the server
+// address and the path of the file on the server. The forms accepted are
+// HOST:PATH and HOST:PORT:PATH, where HOST may be an IPv6 address in brackets,
+// and tftp://HOST[:PORT]/PATH. The port defaults to the TFTP port.
+func parseRemote(spec string) (string, string, error) {
+	if strings.HasPrefix(spec, "tftp://") {
+		return parseRemoteURL(spec)
+	}
+
+	var host, rest string
+	if strings.HasPrefix(spec, "[") {
+		end := strings.Index(spec, "]")
+		if end < 0 || !strings.HasPrefix(spec[end+1:], ":") {
+			return "", "", fmt.Errorf("invalid remote %q, expected [ADDRESS]:PATH", spec)
+		}
+		host, rest = spec[1:end], spec[end+2:]
+	} else {
+		var ok bool
+		host, rest, ok = strings.Cut(spec, ":")
+		if !ok {
+			return "", "", fmt.Errorf("invalid remote %q, expected HOST:PATH", spec)
+		}
+	}
+
+	port := strconv.Itoa(tftp.DefaultPort)
+	if p, path, ok := strings.Cut(rest, ":"); ok && isPort(p) {
+		port, rest = p, path
+	}
+
+	if host == "" || rest == "" {
+		return "", "", fmt.Errorf("invalid remote %q, expected HOST:PATH", spec)
+	}
+	return net.JoinHostPort(host, port), rest, nil
+}
+
+func parseRemoteURL(spec string) (string, string, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return "", "", err
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = strconv.Itoa(tftp.DefaultPort)
+	} else if !isPort(port) {
+		return "", "", fmt.Errorf("invalid port in %q", spec)
+	}
+
+	path := strings.TrimPrefix(u.Path, "/")
+	if u.Hostname() == "" || path == "" {
+		return "", "", errors.New("invalid remote URL, expected tftp://HOST[:PORT]/PATH")
+	}
+	return net.JoinHostPort(u.Hostname(), port), path, nil
+}
+
+func isPort(s string) bool {
+	port, err := strconv.ParseUint(s, 10, 16)
+	return err == nil && port > 0
+}
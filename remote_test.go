@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestParseRemote(t *testing.T) {
+	tests := []struct {
+		spec    string
+		address string
+		path    string
+	}{
+		{spec: "tftp.example.com:hello.txt", address: "tftp.example.com:69", path: "hello.txt"},
+		{spec: "tftp.example.com:6969:hello.txt", address: "tftp.example.com:6969", path: "hello.txt"},
+		{spec: "10.0.0.1:boot/pxelinux.0", address: "10.0.0.1:69", path: "boot/pxelinux.0"},
+		{spec: "host:a:b", address: "host:69", path: "a:b"},
+		{spec: "host:70000:file", address: "host:69", path: "70000:file"},
+		{spec: "[2001:db8::1]:file", address: "[2001:db8::1]:69", path: "file"},
+		{spec: "[2001:db8::1]:6969:file", address: "[2001:db8::1]:6969", path: "file"},
+		{spec: "[fe80::1%eth0]:file", address: "[fe80::1%eth0]:69", path: "file"},
+		{spec: "tftp://tftp.example.com/hello.txt", address: "tftp.example.com:69", path: "hello.txt"},
+		{spec: "tftp://tftp.example.com:6969/dir/hello.txt", address: "tftp.example.com:6969", path: "dir/hello.txt"},
+		{spec: "tftp://[2001:db8::1]:6969/file", address: "[2001:db8::1]:6969", path: "file"},
+		{spec: "tftp://host/a%20b", address: "host:69", path: "a b"},
+	}
+
+	for _, test := range tests {
+		address, path, err := parseRemote(test.spec)
+		if err != nil {
+			t.Errorf("%s: %s", test.spec, err)
+			continue
+		}
+		if address != test.address || path != test.path {
+			t.Errorf("%s: got %q %q, expected %q %q", test.spec, address, path, test.address, test.path)
+		}
+	}
+}
+
+func TestParseRemoteInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"hello.txt",
+		"host:",
+		":file",
+		"host:6969:",
+		"[2001:db8::1]file",
+		"[2001:db8::1:file",
+		"tftp://host",
+		"tftp://host/",
+		"tftp://host:0/file",
+		"tftp:///file",
+	} {
+		if address, path, err := parseRemote(spec); err == nil {
+			t.Errorf("%s: expected an error, got %q %q", spec, address, path)
+		}
+	}
+}
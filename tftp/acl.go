@@ -0,0 +1,117 @@
+package tftp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ACLRule allows or denies requests from a network, optionally only for paths
+// under a prefix.
+type ACLRule struct {
+	Allow   bool
+	Network *net.IPNet
+	// Prefix limits the rule to a path and everything below it. An empty
+	// prefix matches all paths.
+	Prefix string
+}
+
+// ParseACLRule parses a rule in the form "allow|deny NETWORK [PREFIX]".
+// NETWORK is a CIDR, a single IP address or "any".
+func ParseACLRule(s string) (ACLRule, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 || len(fields) > 3 {
+		return ACLRule{}, fmt.Errorf("invalid ACL rule %q", s)
+	}
+
+	var rule ACLRule
+	switch fields[0] {
+	case "allow":
+		rule.Allow = true
+	case "deny":
+	default:
+		return ACLRule{}, fmt.Errorf("invalid ACL rule %q: expected allow or deny", s)
+	}
+
+	network, err := parseNetwork(fields[1])
+	if err != nil {
+		return ACLRule{}, fmt.Errorf("invalid ACL rule %q: %w", s, err)
+	}
+	rule.Network = network
+
+	if len(fields) == 3 {
+		rule.Prefix = fields[2]
+	}
+	return rule, nil
+}
+
+// parseNetwork parses a CIDR, a single IP address or "any".
+func parseNetwork(s string) (*net.IPNet, error) {
+	if s == "any" {
+		return nil, nil
+	}
+
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		return network, err
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func (r ACLRule) matches(ip net.IP, name string) bool {
+	if r.Network != nil && !r.Network.Contains(ip) {
+		return false
+	}
+	return pathHasPrefix(name, r.Prefix)
+}
+
+// pathHasPrefix reports if name is prefix or a path below it.
+func pathHasPrefix(name, prefix string) bool {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" || prefix == "." {
+		return true
+	}
+	return name == prefix || strings.HasPrefix(name, prefix+"/")
+}
+
+// ACL is an ordered list of rules, the first matching rule decides if a
+// request is allowed. An empty ACL allows everything, otherwise requests not
+// matching any rule are denied.
+type ACL []ACLRule
+
+func (acl ACL) allows(ip net.IP, name string) bool {
+	if len(acl) == 0 {
+		return true
+	}
+
+	for _, rule := range acl {
+		if rule.matches(ip, name) {
+			return rule.Allow
+		}
+	}
+	return false
+}
+
+// addrIP returns the IP address of addr, or nil if it doesn't have one.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
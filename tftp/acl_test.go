@@ -0,0 +1,91 @@
+package tftp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func mustParseACL(t *testing.T, rules ...string) ACL {
+	t.Helper()
+
+	var acl ACL
+	for _, rule := range rules {
+		r, err := ParseACLRule(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acl = append(acl, r)
+	}
+	return acl
+}
+
+func TestACL(t *testing.T) {
+	acl := mustParseACL(t,
+		"deny 10.1.2.3",
+		"allow 10.1.0.0/16 configs/",
+		"allow 2001:db8::/32",
+		"deny any secret",
+		"allow any boot",
+	)
+
+	tests := []struct {
+		ip      string
+		path    string
+		allowed bool
+	}{
+		{ip: "10.1.0.5", path: "configs/switch1", allowed: true},
+		{ip: "10.1.2.3", path: "configs/switch1", allowed: false},
+		{ip: "10.2.0.5", path: "configs/switch1", allowed: false},
+		{ip: "10.1.0.5", path: "configs2/switch1", allowed: false},
+		{ip: "192.168.0.1", path: "boot/pxelinux.0", allowed: true},
+		{ip: "192.168.0.1", path: "boot", allowed: true},
+		{ip: "192.168.0.1", path: "bootx", allowed: false},
+		{ip: "2001:db8::1", path: "secret/key", allowed: true},
+		{ip: "2001:db9::1", path: "secret/key", allowed: false},
+	}
+
+	for _, test := range tests {
+		if got := acl.allows(net.ParseIP(test.ip), test.path); got != test.allowed {
+			t.Errorf("%s %s: expected %t, got %t", test.ip, test.path, test.allowed, got)
+		}
+	}
+
+	if !ACL(nil).allows(net.ParseIP("10.0.0.1"), "file") {
+		t.Error("empty ACL should allow everything")
+	}
+}
+
+func TestParseACLRuleInvalid(t *testing.T) {
+	for _, rule := range []string{"", "allow", "permit any", "allow 10.0.0.0/33", "allow host", "allow any a b"} {
+		if _, err := ParseACLRule(rule); err == nil {
+			t.Errorf("%q: expected error", rule)
+		}
+	}
+}
+
+func TestServerACL(t *testing.T) {
+	backend := NewMemoryBackend()
+	backend.WriteFile("boot/kernel", []byte("kernel"))
+	backend.WriteFile("private", []byte("private"))
+	_, addr := startTestServer(t,
+		WithBackend(backend),
+		WithReadACL(mustParseACL(t, "allow any boot/")...),
+		WithWriteACL(mustParseACL(t, "allow 10.0.0.0/8")...),
+	)
+	client := NewClient(addr)
+
+	if err := client.Get("boot/kernel", &bytes.Buffer{}); err != nil {
+		t.Error(err)
+	}
+
+	err := client.Get("private", &bytes.Buffer{})
+	if remoteErr, ok := err.(*RemoteError); !ok || remoteErr.Code != uint16(errAccessViolation) {
+		t.Errorf("expected access violation, got %v", err)
+	}
+
+	err = client.Put("upload", bytes.NewReader([]byte("data")))
+	if remoteErr, ok := err.(*RemoteError); !ok || remoteErr.Code != uint16(errAccessViolation) {
+		t.Errorf("expected access violation, got %v", err)
+	}
+}
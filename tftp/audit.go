@@ -0,0 +1,75 @@
+package tftp
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditRecord is written to the audit log for every request the server
+// receives, once it's been refused or its transfer has ended.
+type AuditRecord struct {
+	Time     time.Time `json:"time"`
+	Transfer uint64    `json:"transfer"`
+	Client   string    `json:"client"`
+	Op       string    `json:"op"`
+	Filename string    `json:"filename"`
+	// Path is where the file is stored, the cleaned filename if the backend
+	// can't tell.
+	Path             string            `json:"path,omitempty"`
+	Mode             string            `json:"mode"`
+	RequestedOptions map[string]string `json:"requested_options,omitempty"`
+	AckedOptions     map[string]string `json:"acked_options,omitempty"`
+	Bytes            int64             `json:"bytes"`
+	Duration         float64           `json:"duration"` // Seconds
+	// Outcome is completed, failed, denied, busy, not_found, exists, invalid
+	// or error, the same values as the requests metric.
+	Outcome string `json:"outcome"`
+	// Reason explains why a request was refused or failed.
+	Reason string `json:"reason,omitempty"`
+}
+
+// auditLog writes records as JSON, one per line.
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *auditLog) write(record *AuditRecord) error {
+	if l == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(data)
+	return err
+}
+
+// pathResolver is implemented by backends that can tell where a path is
+// stored.
+type pathResolver interface {
+	resolvePath(name string) string
+}
+
+// rawOptions returns the options of a request as sent by the client, with
+// lower case names.
+func rawOptions(options [][]byte) map[string]string {
+	if len(options) < 2 {
+		return nil
+	}
+
+	raw := make(map[string]string, len(options)/2)
+	for i := 0; i+1 < len(options); i += 2 {
+		raw[strings.ToLower(string(options[i]))] = string(options[i+1])
+	}
+	return raw
+}
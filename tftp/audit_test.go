@@ -0,0 +1,58 @@
+package tftp
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	var buf syncBuffer
+	root, addr := startTestServer(t, WithAuditLog(&buf), WithDisableWrite)
+	data := randomBytes(3000)
+	if err := os.WriteFile(filepath.Join(root, "file"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(addr, WithClientBlockSize(1024))
+	if err := client.Get("file", &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Put("upload", bytes.NewReader(data)); err == nil {
+		t.Fatal("expected write to be refused")
+	}
+	time.Sleep(50 * time.Millisecond) // The server finishes after the final ACK
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got\n%s", buf.Bytes())
+	}
+
+	records := make(map[string]AuditRecord)
+	for _, line := range lines {
+		var record AuditRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatal(err)
+		}
+		records[record.Op] = record
+	}
+
+	read := records["read"]
+	if read.Filename != "file" || read.Outcome != outcomeCompleted || read.Bytes != int64(len(data)) || read.Mode != ModeOctet {
+		t.Errorf("unexpected read record %+v", read)
+	}
+	if read.Path != filepath.Join(root, "file") {
+		t.Errorf("expected path %q, got %q", filepath.Join(root, "file"), read.Path)
+	}
+	if read.RequestedOptions["blksize"] != "1024" || read.AckedOptions["blksize"] != "1024" {
+		t.Errorf("expected blksize options, got %v and %v", read.RequestedOptions, read.AckedOptions)
+	}
+
+	write := records["write"]
+	if write.Filename != "upload" || write.Outcome != outcomeDenied || write.Reason != "Writes disabled" || write.Bytes != 0 {
+		t.Errorf("unexpected write record %+v", write)
+	}
+}
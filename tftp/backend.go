@@ -0,0 +1,397 @@
+package tftp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Backend provides the files served by a Server. Paths are slash separated,
+// cleaned and relative to the root of the backend, "." is the root itself.
+type Backend interface {
+	// Open opens the file at path for reading.
+	Open(path string) (io.ReadCloser, error)
+	// Create creates the file at path for writing, replacing it if it exists.
+	// If the writer implements Committer the file is only replaced once the
+	// transfer completes.
+	Create(path string) (io.WriteCloser, error)
+	// Stat returns information about the file at path.
+	Stat(path string) (fs.FileInfo, error)
+	// Exists reports if a file exists at path.
+	Exists(path string) bool
+}
+
+// Committer is implemented by writers returned from Backend.Create that store
+// the file atomically. Commit is called once the last block of an upload has
+// been written. Closing the writer without calling Commit discards the upload.
+type Committer interface {
+	Commit() error
+}
+
+// SymlinkPolicy controls how a DirBackend treats symbolic links.
+type SymlinkPolicy int
+
+const (
+	// SymlinksWithinRoot follows symbolic links that resolve to a path inside
+	// the root directory.
+	SymlinksWithinRoot SymlinkPolicy = iota
+	// SymlinksNever rejects any path containing a symbolic link.
+	SymlinksNever
+	// SymlinksAlways follows all symbolic links, even out of the root.
+	SymlinksAlways
+)
+
+// ParseSymlinkPolicy parses "root", "never" or "always" into a SymlinkPolicy.
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	switch s {
+	case "root":
+		return SymlinksWithinRoot, nil
+	case "never":
+		return SymlinksNever, nil
+	case "always":
+		return SymlinksAlways, nil
+	}
+	return 0, fmt.Errorf("unknown symlink policy %q", s)
+}
+
+// DirOption configures a DirBackend.
+type DirOption func(*dirBackend)
+
+// WithSymlinkPolicy sets how symbolic links are followed, the default is
+// SymlinksWithinRoot.
+func WithSymlinkPolicy(policy SymlinkPolicy) DirOption {
+	return func(b *dirBackend) {
+		b.symlinks = policy
+	}
+}
+
+// dirBackend serves files from a directory on the local filesystem.
+type dirBackend struct {
+	root     string
+	symlinks SymlinkPolicy
+}
+
+// DirBackend returns a Backend serving files from the local directory root.
+// Paths resolving outside of root are rejected with fs.ErrPermission.
+func DirBackend(root string, options ...DirOption) Backend {
+	b := &dirBackend{root: root}
+	for _, option := range options {
+		option(b)
+	}
+	return b
+}
+
+func (b *dirBackend) String() string {
+	fullpath, _ := filepath.Abs(b.root)
+	return fullpath
+}
+
+// resolve returns the filesystem path for name after applying the symlink
+// policy. Symbolic links are resolved so the returned path can't be moved out
+// of the root by changing a link after it was checked.
+func (b *dirBackend) resolve(op, name string) (string, error) {
+	full := filepath.Join(b.root, filepath.FromSlash(name))
+	if b.symlinks == SymlinksAlways {
+		return full, nil
+	}
+
+	root, err := filepath.EvalSymlinks(b.root)
+	if err != nil {
+		return "", err
+	}
+
+	resolved, err := filepath.EvalSymlinks(full)
+	if errors.Is(err, fs.ErrNotExist) {
+		// The file doesn't exist yet, confine the directory it would be
+		// created in. Uploads are renamed into place so a dangling link
+		// at full is replaced, not followed.
+		dir, err := filepath.EvalSymlinks(filepath.Dir(full))
+		if err != nil {
+			return "", err
+		}
+		resolved = filepath.Join(dir, filepath.Base(full))
+	} else if err != nil {
+		return "", err
+	}
+
+	denied := &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", denied
+	}
+	if b.symlinks == SymlinksNever && rel != filepath.Clean(filepath.FromSlash(name)) {
+		return "", denied
+	}
+	return resolved, nil
+}
+
+func (b *dirBackend) resolvePath(name string) string {
+	path, err := b.resolve("stat", name)
+	if err != nil {
+		path = filepath.Join(b.root, filepath.FromSlash(name))
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+func (b *dirBackend) Open(name string) (io.ReadCloser, error) {
+	path, err := b.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err == nil && stat.IsDir() {
+		err = &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Create writes uploads to a temporary file in the same directory which is
+// renamed over the destination on Commit. Directories, the root included, are
+// refused before anything is written.
+func (b *dirBackend) Create(name string) (io.WriteCloser, error) {
+	dest, err := b.resolve("create", name)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := os.FileMode(0644)
+	if stat, err := os.Stat(dest); err == nil {
+		if stat.IsDir() {
+			return nil, &fs.PathError{Op: "create", Path: name, Err: syscall.EISDIR}
+		}
+		mode = stat.Mode().Perm()
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(mode); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &atomicFile{File: file, dest: dest}, nil
+}
+
+func (b *dirBackend) Stat(name string) (fs.FileInfo, error) {
+	path, err := b.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(path)
+}
+
+func (b *dirBackend) Exists(name string) bool {
+	_, err := b.Stat(name)
+	return err == nil
+}
+
+// atomicFile is a temporary file renamed to dest on Commit and removed if it's
+// closed without being committed.
+type atomicFile struct {
+	*os.File
+	dest      string
+	committed bool
+	closed    bool
+}
+
+func (f *atomicFile) Commit() error {
+	if err := f.File.Sync(); err != nil {
+		return err
+	}
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	f.closed = true
+
+	if err := os.Rename(f.File.Name(), f.dest); err != nil {
+		return err
+	}
+	f.committed = true
+	return nil
+}
+
+func (f *atomicFile) Close() error {
+	if f.committed {
+		return nil
+	}
+
+	var err error
+	if !f.closed {
+		err = f.File.Close()
+		f.closed = true
+	}
+	os.Remove(f.File.Name())
+	return err
+}
+
+// fsBackend serves files from an fs.FS. It's read-only.
+type fsBackend struct {
+	fsys fs.FS
+}
+
+// FSBackend returns a read-only Backend serving files from fsys, such as an
+// embed.FS or os.DirFS.
+func FSBackend(fsys fs.FS) Backend {
+	return &fsBackend{fsys: fsys}
+}
+
+func (b *fsBackend) String() string {
+	return "fs.FS"
+}
+
+func (b *fsBackend) Open(name string) (io.ReadCloser, error) {
+	return b.fsys.Open(name)
+}
+
+func (b *fsBackend) Create(name string) (io.WriteCloser, error) {
+	return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrPermission}
+}
+
+func (b *fsBackend) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(b.fsys, name)
+}
+
+func (b *fsBackend) Exists(name string) bool {
+	_, err := fs.Stat(b.fsys, name)
+	return err == nil
+}
+
+// MemoryBackend is a Backend keeping files in memory. Files written by
+// clients are only stored once their transfer completes.
+type MemoryBackend struct {
+	mu    sync.RWMutex
+	files map[string]memoryFile
+}
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemoryBackend returns an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{files: make(map[string]memoryFile)}
+}
+
+func (b *MemoryBackend) String() string {
+	return "memory"
+}
+
+// WriteFile stores data as the file at path.
+func (b *MemoryBackend) WriteFile(path string, data []byte) {
+	b.mu.Lock()
+	b.files[path] = memoryFile{data: append([]byte(nil), data...), modTime: time.Now()}
+	b.mu.Unlock()
+}
+
+// ReadFile returns the contents of the file at path.
+func (b *MemoryBackend) ReadFile(path string) ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	file, ok := b.files[path]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: path, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), file.data...), nil
+}
+
+// Files returns the paths of all stored files in sorted order.
+func (b *MemoryBackend) Files() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	paths := make([]string, 0, len(b.files))
+	for path := range b.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (b *MemoryBackend) Open(path string) (io.ReadCloser, error) {
+	data, err := b.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *MemoryBackend) Create(path string) (io.WriteCloser, error) {
+	return &memoryWriter{backend: b, path: path}, nil
+}
+
+func (b *MemoryBackend) Stat(path string) (fs.FileInfo, error) {
+	if path == "." {
+		return &fileInfo{name: ".", mode: fs.ModeDir | 0755}, nil
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	file, ok := b.files[path]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+	}
+	return &fileInfo{name: path, size: int64(len(file.data)), mode: 0644, modTime: file.modTime}, nil
+}
+
+func (b *MemoryBackend) Exists(path string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	_, ok := b.files[path]
+	return ok
+}
+
+type memoryWriter struct {
+	bytes.Buffer
+	backend *MemoryBackend
+	path    string
+}
+
+func (w *memoryWriter) Commit() error {
+	w.backend.WriteFile(w.path, w.Bytes())
+	return nil
+}
+
+func (w *memoryWriter) Close() error {
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,250 @@
+package tftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	backend := NewMemoryBackend()
+	backend.WriteFile("boot/kernel", []byte("kernel"))
+	_, addr := startTestServer(t, WithBackend(backend))
+	client := NewClient(addr)
+
+	var buf bytes.Buffer
+	if err := client.Get("boot/kernel", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "kernel" {
+		t.Errorf("expected kernel, got %q", buf.String())
+	}
+
+	if err := client.Put("configs/switch1", bytes.NewReader([]byte("config"))); err != nil {
+		t.Fatal(err)
+	}
+	data, err := backend.ReadFile("configs/switch1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "config" {
+		t.Errorf("expected config, got %q", data)
+	}
+}
+
+func TestFSBackend(t *testing.T) {
+	fsys := fstest.MapFS{
+		"boot/pxelinux.0": {Data: []byte("pxelinux")},
+	}
+	_, addr := startTestServer(t, WithBackend(FSBackend(fsys)))
+	client := NewClient(addr)
+
+	var buf bytes.Buffer
+	if err := client.Get("/boot/pxelinux.0", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "pxelinux" {
+		t.Errorf("expected pxelinux, got %q", buf.String())
+	}
+
+	err := client.Put("upload", bytes.NewReader([]byte("data")))
+	if remoteErr, ok := err.(*RemoteError); !ok || remoteErr.Code != uint16(errAccessViolation) {
+		t.Errorf("expected access violation, got %v", err)
+	}
+}
+
+func TestDirBackendAtomicCreate(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "image"), []byte("original"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	backend := DirBackend(root)
+
+	// Closing without committing discards the upload
+	w, err := backend.Create("image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("partial"))
+	w.Close()
+	assertDir(t, root, map[string]string{"image": "original"})
+
+	w, err = backend.Create("image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("replaced"))
+	if err := w.(Committer).Commit(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	assertDir(t, root, map[string]string{"image": "replaced"})
+
+	stat, err := os.Stat(filepath.Join(root, "image"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode().Perm() != 0640 {
+		t.Errorf("expected mode 0640 to be kept, got %o", stat.Mode().Perm())
+	}
+}
+
+func TestDirBackendRefusesDirectories(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	if err := os.MkdirAll(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	backend := DirBackend(root)
+
+	for _, name := range []string{".", "dir"} {
+		if _, err := backend.Open(name); err == nil {
+			t.Errorf("%s: expected open to fail", name)
+		}
+		if _, err := backend.Create(name); err == nil {
+			t.Errorf("%s: expected create to fail", name)
+		}
+	}
+
+	// No temporary files are left in the root or its parent
+	for _, dir := range []string{root, filepath.Dir(root)} {
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("%s: expected a single entry, got %d", dir, len(entries))
+		}
+	}
+}
+
+type failingReader struct {
+	r     io.Reader
+	after int
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.after <= 0 {
+		return 0, errors.New("read failed")
+	}
+	if len(p) > f.after {
+		p = p[:f.after]
+	}
+	n, err := f.r.Read(p)
+	f.after -= n
+	return n, err
+}
+
+func TestAbortedUploadDiscarded(t *testing.T) {
+	root, addr := startTestServer(t, WithAllowOverwrite)
+	if err := os.WriteFile(filepath.Join(root, "image"), []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data := &failingReader{r: bytes.NewReader(randomBytes(10000)), after: 5000}
+	if err := NewClient(addr, WithClientBlockSize(512)).Put("image", data); err == nil {
+		t.Fatal("expected upload to fail")
+	}
+
+	// The server discards the upload when it receives the client's error
+	deadline := time.Now().Add(time.Second)
+	for {
+		entries, _ := os.ReadDir(root)
+		if len(entries) == 1 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertDir(t, root, map[string]string{"image": "original"})
+}
+
+func assertDir(t *testing.T, dir string, expected map[string]string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(expected) {
+		t.Errorf("expected %d files, got %d", len(expected), len(entries))
+	}
+	for name, content := range expected {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s: expected %q, got %q", name, content, data)
+		}
+	}
+}
+
+func TestDirBackendSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "file"), []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Symlink("file", filepath.Join(root, "inside"))
+	os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "outside"))
+	os.Symlink(outside, filepath.Join(root, "outdir"))
+
+	tests := []struct {
+		policy SymlinkPolicy
+		path   string
+		ok     bool
+	}{
+		{policy: SymlinksWithinRoot, path: "file", ok: true},
+		{policy: SymlinksWithinRoot, path: "inside", ok: true},
+		{policy: SymlinksWithinRoot, path: "outside", ok: false},
+		{policy: SymlinksWithinRoot, path: "outdir/secret", ok: false},
+		{policy: SymlinksNever, path: "file", ok: true},
+		{policy: SymlinksNever, path: "inside", ok: false},
+		{policy: SymlinksAlways, path: "outside", ok: true},
+		{policy: SymlinksAlways, path: "outdir/secret", ok: true},
+	}
+
+	for _, test := range tests {
+		backend := DirBackend(root, WithSymlinkPolicy(test.policy))
+		f, err := backend.Open(test.path)
+		if test.ok && err != nil {
+			t.Errorf("policy %d %s: %s", test.policy, test.path, err)
+		} else if !test.ok && !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("policy %d %s: expected permission error, got %v", test.policy, test.path, err)
+		}
+		if f != nil {
+			f.Close()
+		}
+	}
+
+	// Uploads through a link to a directory outside the root are rejected
+	if _, err := DirBackend(root).Create("outdir/new"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected permission error, got %v", err)
+	}
+}
+
+func TestServerRejectsEscape(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	err := NewClient(addr).Get("../etc/passwd", &bytes.Buffer{})
+	if remoteErr, ok := err.(*RemoteError); !ok || remoteErr.Code != uint16(errAccessViolation) {
+		t.Errorf("expected access violation, got %v", err)
+	}
+}
+
+func TestServerRejectsRoot(t *testing.T) {
+	_, addr := startTestServer(t, WithAllowOverwrite)
+
+	for _, name := range []string{".", "/", ""} {
+		err := NewClient(addr).Put(name, bytes.NewReader([]byte("data")))
+		if remoteErr, ok := err.(*RemoteError); !ok || remoteErr.Code != uint16(errAccessViolation) {
+			t.Errorf("%q: expected access violation, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// defaultClientBlockSize fits a DATA packet in a standard 1500 byte MTU
+// Ethernet frame with room to spare for IP and UDP headers.
+const defaultClientBlockSize = 1428
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// Client transfers files to and from a single TFTP server.
+type Client struct {
+	addr       string
+	blockSize  int
+	windowSize int
+	mode       string
+	rollover   int
+	rateLimit  int64
+	rfc1350    bool
+	logger     *slog.Logger
+	hooks      Hooks
+	retransmit retransmitConfig
+}
+
+// NewClient returns a Client for the server at addr. addr must include a
+// port, use DefaultPort for the standard TFTP port.
+func NewClient(addr string, options ...ClientOption) *Client {
+	c := &Client{
+		addr:       addr,
+		blockSize:  defaultClientBlockSize,
+		windowSize: defaultOptions.windowSize,
+		mode:       ModeOctet,
+		rollover:   -1,
+		logger:     slog.Default(),
+		retransmit: defaultRetransmit,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// WithClientBlockSize sets the block size requested from the server.
+func WithClientBlockSize(size int) ClientOption {
+	return func(c *Client) {
+		c.blockSize = size
+	}
+}
+
+// WithClientWindowSize sets the number of blocks per window requested from
+// the server as described in RFC 7440.
+func WithClientWindowSize(size int) ClientOption {
+	return func(c *Client) {
+		c.windowSize = size
+	}
+}
+
+// WithClientMode sets the transfer mode, ModeOctet or ModeNetascii. Netascii
+// transfers translate line endings between LF and CR LF.
+func WithClientMode(mode string) ClientOption {
+	return func(c *Client) {
+		c.mode = mode
+	}
+}
+
+// WithClientRollover sets the block number used after block 65535, either 0
+// or 1, and requests it from the server with the rollover option. By default
+// the option isn't sent, the client sends block 0 and accepts either.
+func WithClientRollover(block int) ClientOption {
+	return func(c *Client) {
+		c.rollover = block
+	}
+}
+
+// WithClientRateLimit limits transfers to bytesPerSec.
+func WithClientRateLimit(bytesPerSec int64) ClientOption {
+	return func(c *Client) {
+		c.rateLimit = bytesPerSec
+	}
+}
+
+// WithClientRetries sets how many times a packet is sent again after timeouts
+// before the transfer fails, 5 by default.
+func WithClientRetries(retries int) ClientOption {
+	return func(c *Client) {
+		c.retransmit.retries = retries
+	}
+}
+
+// WithClientAdaptiveTimeout derives the retransmission timeout from the
+// measured round trip time, see WithAdaptiveTimeout.
+func WithClientAdaptiveTimeout(c *Client) {
+	c.retransmit.adaptive = true
+}
+
+// WithClientMaxTimeout sets the ceiling of the adaptive timeout, 30 seconds by
+// default.
+func WithClientMaxTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.retransmit.maxTimeout = timeout
+	}
+}
+
+// WithClientRFC1350 disables TFTP option extensions.
+func WithClientRFC1350(c *Client) {
+	c.rfc1350 = true
+}
+
+// WithClientLogger sets the logger used for transfers, slog.Default() is used
+// by default. Debug messages describe every packet.
+func WithClientLogger(logger *slog.Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithClientHooks sets functions called when transfers start, make progress,
+// complete or fail.
+func WithClientHooks(hooks Hooks) ClientOption {
+	return func(c *Client) {
+		c.hooks = hooks
+	}
+}
+
+func (c *Client) limiters() []*rateLimiter {
+	if c.rateLimit > 0 {
+		return []*rateLimiter{newRateLimiter(c.rateLimit)}
+	}
+	return nil
+}
+
+// dial opens a socket for the transfer with id of remotePath, op is the
+// transfer's op from the server's point of view.
+func (c *Client) dial(id uint64, op opCode, remotePath string) (*requestConn, error) {
+	if c.mode != ModeOctet && c.mode != ModeNetascii {
+		return nil, fmt.Errorf("unsupported transfer mode %q", c.mode)
+	}
+	if c.rollover > 1 {
+		return nil, fmt.Errorf("invalid rollover block %d", c.rollover)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	network := "udp6"
+	if addr.IP.To4() != nil {
+		network = "udp4"
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, err
+	}
+	logger := c.logger.With(
+		"transfer", id,
+		"peer", addr.String(),
+		"file", remotePath,
+		"direction", direction(op),
+	)
+	return &requestConn{conn: conn, addr: addr, logger: logger}, nil
+}
+
+// Get downloads the remote file and writes it to w.
+func (c *Client) Get(remotePath string, w io.Writer) error {
+	id := nextTransferID()
+	conn, err := c.dial(id, opWrite, remotePath)
+	if err != nil {
+		return err
+	}
+
+	opts := defaultOptions.copy()
+
+	conn.log().Debug("Sending read request")
+	if c.rfc1350 {
+		conn.sendReadRequest(remotePath, c.mode, nil)
+	} else {
+		opts.blockSize = c.blockSize
+		opts.windowSize = c.windowSize
+		opts.rollover = c.rollover
+		conn.sendReadRequest(remotePath, c.mode, opts.toMap())
+		// The transfer will respond to OACKS and retransmit if needed.
+	}
+
+	options := defaultOptions.copy()
+	options.rollover = c.rollover
+
+	t := &transfer{
+		id:               id,
+		op:               opWrite, // From the client we're writing to a file
+		request:          TransferRead,
+		conn:             conn,
+		dst:              w,
+		options:          options,
+		requestedOptions: opts,
+		remotePath:       remotePath,
+		mode:             c.mode,
+		limiters:         c.limiters(),
+		hooks:            c.hooks,
+		rto:              newRTO(c.retransmit, options),
+	}
+
+	return t.run()
+}
+
+// Put uploads the contents of r to the remote file. If r has a Stat or Len
+// method the size is sent to the server with the tsize option.
+func (c *Client) Put(remotePath string, r io.Reader) error {
+	id := nextTransferID()
+	conn, err := c.dial(id, opRead, remotePath)
+	if err != nil {
+		return err
+	}
+
+	opts := defaultOptions.copy()
+	var reqOptions map[string]string
+
+	conn.log().Debug("Sending write request")
+	if !c.rfc1350 {
+		opts.blockSize = c.blockSize
+		opts.windowSize = c.windowSize
+		opts.rollover = c.rollover
+		if file, ok := r.(stater); ok {
+			if stat, err := file.Stat(); err == nil {
+				opts.tsize = stat.Size()
+			}
+		} else if buf, ok := r.(lengther); ok {
+			opts.tsize = int64(buf.Len())
+		}
+		reqOptions = opts.toMap()
+	}
+	conn.sendWriteRequest(remotePath, c.mode, reqOptions)
+
+	t := &transfer{
+		id:         id,
+		op:         opRead, // From the client we're reading a file to the server
+		request:    TransferWrite,
+		conn:       conn,
+		src:        r,
+		options:    defaultOptions.copy(),
+		remotePath: remotePath,
+		mode:       c.mode,
+		limiters:   c.limiters(),
+		hooks:      c.hooks,
+		rto:        newRTO(c.retransmit, defaultOptions),
+		start:      time.Now(),
+	}
+
+	// Wait for server to ACK write request and/or options
+	sent := t.start
+	for {
+		resp := conn.readMessageBefore(opRead, defaultOptions, time.Now().Add(t.rto.current()))
+		if resp == nil {
+			conn.Close()
+			return t.failed(errors.New("write request failed"))
+		}
+
+		if resp.op == opError {
+			conn.Close()
+			return t.failed(&RemoteError{Code: resp.errorCode, Message: resp.errorMsg})
+		} else if resp.op == opRetransmit {
+			if !t.rto.expired() {
+				conn.Close()
+				return t.failed(errMaxRetransmits)
+			}
+
+			conn.log().Debug("Retransmitting write request", "timeout", t.rto.current())
+			conn.sendWriteRequest(remotePath, c.mode, reqOptions)
+			continue
+		} else if resp.op == opOAck {
+			t.options = resp.options
+			t.rto.answered(time.Since(sent))
+			t.rto.negotiated(t.options)
+			break
+		} else if resp.op == opAck {
+			t.rto.answered(time.Since(sent))
+			break
+		} else {
+			conn.sendError(errIllegalOperation, "Invalid operation for write request")
+			conn.Close()
+			return t.failed(fmt.Errorf("unexpected %s packet", resp.op))
+		}
+	}
+
+	if t.options.rollover < 0 { // Server didn't acknowledge rollover
+		t.options.rollover = c.rollover
+	}
+
+	return t.run()
+}
@@ -0,0 +1,282 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"time"
+)
+
+type requestConn struct {
+	conn net.PacketConn
+	addr net.Addr
+	// latched is set once addr is the peer's transfer ID. Until then the
+	// first reply from addr's IP address sets it, a client sends its request
+	// to the server's well-known port but the server replies from another.
+	latched bool
+	logger  *slog.Logger    // Carries the transfer's attributes, may be nil
+	ctx     context.Context // Cancelling it interrupts reads, may be nil
+	metrics *Metrics        // May be nil
+}
+
+// log returns the connection's logger, the default logger if it doesn't have
+// one.
+func (conn *requestConn) log() *slog.Logger {
+	if conn.logger == nil {
+		return slog.Default()
+	}
+	return conn.logger
+}
+
+func (conn *requestConn) Close() error {
+	return conn.conn.Close()
+}
+
+// done returns the Done channel of the connection's context, nil if it
+// doesn't have one.
+func (conn *requestConn) done() <-chan struct{} {
+	if conn.ctx == nil {
+		return nil
+	}
+	return conn.ctx.Done()
+}
+
+// cancelled reports if the connection's context has been cancelled.
+func (conn *requestConn) cancelled() bool {
+	return conn.ctx != nil && conn.ctx.Err() != nil
+}
+
+// watch interrupts a blocked read once the connection's context is cancelled.
+// The returned function stops watching.
+func (conn *requestConn) watch() func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-conn.done():
+			conn.conn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
+
+func (conn *requestConn) sendReadRequest(filename, mode string, options map[string]string) {
+	conn.sendRWRequest(opRead, filename, mode, options)
+}
+
+func (conn *requestConn) sendWriteRequest(filename, mode string, options map[string]string) {
+	conn.sendRWRequest(opWrite, filename, mode, options)
+}
+
+func (conn *requestConn) sendRWRequest(op opCode, filename, mode string, options map[string]string) {
+	filenameBytes := []byte(filename)
+	modeBytes := []byte(mode)
+	initialSize := 4 + len(filenameBytes) + len(modeBytes)
+	resp := make([]byte, initialSize, initialSize+totalStringMapLen(options))
+	// Op code
+	resp[0] = byte(op >> 8)
+	resp[1] = byte(op)
+	// Filename
+	copy(resp[2:len(filenameBytes)+2], filenameBytes)
+	resp[len(filenameBytes)+2] = 0 // Null terminator
+	// Mode
+	copy(resp[len(filenameBytes)+3:], modeBytes)
+	resp[len(resp)-1] = 0 // Null terminator
+
+	for k, v := range options {
+		resp = append(resp, []byte(k)...)
+		resp = append(resp, 0)
+		resp = append(resp, []byte(v)...)
+		resp = append(resp, 0)
+	}
+
+	conn.conn.WriteTo(resp, conn.addr)
+}
+
+func (conn *requestConn) sendData(blockID uint16, data []byte) {
+	resp := make([]byte, 4+len(data))
+	// Op code
+	resp[0] = byte(opData >> 8)
+	resp[1] = byte(opData)
+	// Block #
+	resp[2] = byte(blockID >> 8)
+	resp[3] = byte(blockID)
+	// Data
+	copy(resp[4:], data)
+
+	conn.conn.WriteTo(resp, conn.addr)
+}
+
+func (conn *requestConn) sendAck(blockID uint16) {
+	resp := make([]byte, 4)
+	// Op code
+	resp[0] = byte(opAck >> 8)
+	resp[1] = byte(opAck)
+	// Block #
+	resp[2] = byte(blockID >> 8)
+	resp[3] = byte(blockID)
+
+	conn.conn.WriteTo(resp, conn.addr)
+}
+
+func (conn *requestConn) sendOAck(options map[string]string) {
+	resp := make([]byte, 2, 2+totalStringMapLen(options))
+	// Op code
+	resp[0] = byte(opOAck >> 8)
+	resp[1] = byte(opOAck)
+	// Options
+	for k, v := range options {
+		resp = append(resp, []byte(k)...)
+		resp = append(resp, 0)
+		resp = append(resp, []byte(v)...)
+		resp = append(resp, 0)
+	}
+
+	conn.conn.WriteTo(resp, conn.addr)
+}
+
+func (conn *requestConn) sendError(code tftpError, msg string) {
+	conn.sendErrorTo(conn.addr, code, msg)
+}
+
+func (conn *requestConn) sendErrorTo(addr net.Addr, code tftpError, msg string) {
+	conn.metrics.errorSent(code)
+	msgBytes := []byte(msg)
+
+	resp := make([]byte, 5+len(msgBytes))
+	// Op code
+	resp[0] = byte(opError >> 8)
+	resp[1] = byte(opError)
+	// Error code
+	resp[2] = byte(code >> 8)
+	resp[3] = byte(code)
+	// Human-readable message
+	copy(resp[4:len(resp)-1], msgBytes)
+	// Null terminator
+	resp[len(resp)-1] = 0
+
+	conn.conn.WriteTo(resp, addr)
+}
+
+// readMessageBefore reads the next message, timing out at deadline. Callers
+// ignoring some messages pass the same deadline again so those can't hold off
+// a timeout.
+func (conn *requestConn) readMessageBefore(op opCode, options *tftpOptions, deadline time.Time) *response {
+	var buffer []byte
+	if op == opRead {
+		buffer = make([]byte, defaultOptions.blockSize)
+	} else {
+		buffer = make([]byte, options.blockSize+4)
+	}
+
+	conn.conn.SetReadDeadline(deadline)
+	// Checked after setting the deadline so a cancellation can't be missed
+	if conn.cancelled() {
+		return nil
+	}
+
+	var n int
+	for {
+		var addr net.Addr
+		var err error
+		n, addr, err = conn.conn.ReadFrom(buffer)
+		if err != nil {
+			if conn.cancelled() {
+				return nil
+			}
+			netErr := err.(net.Error)
+			if netErr.Timeout() {
+				conn.metrics.timeout()
+				return conn.logPacket(&response{op: opRetransmit})
+			}
+			conn.log().Error("Read failed", "error", err)
+			return nil
+		}
+
+		if conn.fromPeer(addr) {
+			break
+		}
+		// Not part of this transfer, tell the sender without ending it. The
+		// read deadline is kept so strangers can't hold off a timeout.
+		conn.log().Warn("Received packet from unknown transfer ID", "source", addr.String())
+		conn.sendErrorTo(addr, errUnknownTID, "Unknown transfer ID")
+	}
+
+	if n < 4 {
+		conn.sendError(errNotDefined, "Malformatted message")
+		return nil
+	}
+
+	recv := buffer[:n]
+
+	opcode := opCode(decodeUInt16(recv[:2]))
+
+	switch opcode {
+	case opAck:
+		return conn.logPacket(&response{
+			op:      opAck,
+			blockID: decodeUInt16(recv[2:4]),
+		})
+	case opError:
+		errorMsg := ""
+		if len(recv) > 4 {
+			errorMsg = string(recv[4 : len(recv)-1]) // Strip null terminator
+		}
+
+		return conn.logPacket(&response{
+			op:        opError,
+			errorCode: decodeUInt16(recv[2:4]),
+			errorMsg:  errorMsg,
+		})
+	case opData:
+		return conn.logPacket(&response{
+			op:      opData,
+			blockID: decodeUInt16(recv[2:4]),
+			data:    recv[4:],
+		})
+	case opOAck:
+		options, _ := parseOptions(bytes.Split(recv[2:], []byte{0}))
+		return conn.logPacket(&response{
+			op:      opOAck,
+			options: options,
+		})
+	default:
+		conn.sendError(errIllegalOperation, "")
+		return nil
+	}
+}
+
+// fromPeer reports if a packet from addr belongs to the transfer, latching the
+// peer's transfer ID if it isn't yet.
+func (conn *requestConn) fromPeer(addr net.Addr) bool {
+	if addr.String() == conn.addr.String() {
+		conn.latched = true
+		return true
+	}
+	if conn.latched {
+		return false
+	}
+
+	from, ok := addr.(*net.UDPAddr)
+	peer, ok2 := conn.addr.(*net.UDPAddr)
+	if !ok || !ok2 || !from.IP.Equal(peer.IP) {
+		return false
+	}
+	conn.addr, conn.latched = addr, true
+	return true
+}
+
+func (conn *requestConn) logPacket(r *response) *response {
+	switch r.op {
+	case opRetransmit:
+		conn.log().Debug("Read timed out")
+	case opData, opAck:
+		conn.log().Debug("Received packet", "op", r.op.String(), "block", r.blockID)
+	case opError:
+		conn.log().Debug("Received packet", "op", r.op.String(), "code", r.errorCode, "message", r.errorMsg)
+	default:
+		conn.log().Debug("Received packet", "op", r.op.String())
+	}
+	return r
+}
@@ -0,0 +1,164 @@
+package tftp
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testPacketConn struct {
+	nextWrite []byte
+	nextRead  []byte
+	t         *testing.T
+}
+
+func (c *testPacketConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	copy(b, c.nextRead)
+	if len(b) > len(c.nextRead) {
+		return len(c.nextRead), nil, nil
+	}
+	return len(b), nil, nil
+}
+
+func (c *testPacketConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	if !bytes.Equal(b, c.nextWrite) {
+		c.t.Errorf("expected %#v, got %#v", c.nextWrite, b)
+	}
+	return len(b), nil
+}
+
+func (c *testPacketConn) Close() error                       { return nil }
+func (c *testPacketConn) LocalAddr() net.Addr                { return nil }
+func (c *testPacketConn) SetDeadline(t time.Time) error      { return nil }
+func (c *testPacketConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *testPacketConn) SetWriteDeadline(t time.Time) error { return nil }
+func (c *testPacketConn) setNextWrite(req []byte)            { c.nextWrite = req }
+func (c *testPacketConn) setNextRead(resp []byte)            { c.nextRead = resp }
+
+func TestAck(t *testing.T) {
+	c := &testPacketConn{t: t}
+	c.setNextWrite([]byte{0, 4, 0, 10})
+	conn := &requestConn{conn: c}
+	conn.sendAck(10)
+
+	c.setNextWrite([]byte{0, 4, 1, 0})
+	conn.sendAck(256)
+}
+
+func TestOAck(t *testing.T) {
+	c := &testPacketConn{t: t}
+	next := []byte{0, 6}
+	next = append(next, []byte("blksize")...)
+	next = append(next, 0)
+	next = append(next, []byte("1024")...)
+	next = append(next, 0)
+	c.setNextWrite(next)
+
+	conn := &requestConn{conn: c}
+	conn.sendOAck(map[string]string{
+		"blksize": "1024",
+	})
+}
+
+// readPacket reads a packet from conn, failing the test after a second.
+func readPacket(t *testing.T, conn net.PacketConn) ([]byte, net.Addr) {
+	t.Helper()
+
+	buffer := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, addr, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer[:n], addr
+}
+
+func listenLoopback(t *testing.T) net.PacketConn {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func expectUnknownTID(t *testing.T, stranger net.PacketConn) {
+	t.Helper()
+
+	packet, _ := readPacket(t, stranger)
+	if opCode(decodeUInt16(packet[:2])) != opError || tftpError(decodeUInt16(packet[2:4])) != errUnknownTID {
+		t.Fatalf("expected an unknown transfer ID error, got %v", packet)
+	}
+}
+
+func TestServerUnknownTID(t *testing.T) {
+	root, addr := startTestServer(t)
+	data := randomBytes(1000)
+	if err := os.WriteFile(filepath.Join(root, "file"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	server, _ := net.ResolveUDPAddr("udp", addr)
+
+	clientConn := listenLoopback(t)
+	client := &requestConn{conn: clientConn, addr: server}
+	client.sendReadRequest("file", ModeOctet, nil)
+
+	packet, tid := readPacket(t, clientConn)
+	if !bytes.Equal(packet[4:], data[:512]) {
+		t.Fatalf("unexpected first block %v", packet[:4])
+	}
+
+	// A stranger acknowledging the block is refused without ending the
+	// transfer
+	stranger := listenLoopback(t)
+	(&requestConn{conn: stranger, addr: tid}).sendAck(1)
+	expectUnknownTID(t, stranger)
+
+	client.addr = tid
+	client.sendAck(1)
+	packet, _ = readPacket(t, clientConn)
+	if decodeUInt16(packet[2:4]) != 2 || !bytes.Equal(packet[4:], data[512:]) {
+		t.Fatalf("unexpected second block %v", packet[:4])
+	}
+	client.sendAck(2)
+}
+
+func TestClientUnknownTID(t *testing.T) {
+	server := listenLoopback(t)
+	data := randomBytes(600)
+
+	var buf bytes.Buffer
+	got := make(chan error, 1)
+	go func() {
+		got <- NewClient(server.LocalAddr().String(), WithClientRFC1350).Get("file", &buf)
+	}()
+
+	_, clientAddr := readPacket(t, server)
+	tid := listenLoopback(t)
+	transfer := &requestConn{conn: tid, addr: clientAddr}
+	transfer.sendData(1, data[:512])
+	if packet, _ := readPacket(t, tid); decodeUInt16(packet[2:4]) != 1 {
+		t.Fatalf("expected ACK 1, got %v", packet)
+	}
+
+	// The client latched tid, a stranger's block must not be accepted
+	stranger := listenLoopback(t)
+	(&requestConn{conn: stranger, addr: clientAddr}).sendData(2, []byte("hijacked"))
+	expectUnknownTID(t, stranger)
+
+	transfer.sendData(2, data[512:])
+	if packet, _ := readPacket(t, tid); decodeUInt16(packet[2:4]) != 2 {
+		t.Fatalf("expected ACK 2, got %v", packet)
+	}
+	if err := <-got; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("received %d bytes, expected %d", buf.Len(), len(data))
+	}
+}
@@ -1,14 +1,16 @@
-package main
+package tftp
 
 import (
+	"log/slog"
 	"strconv"
 	"time"
 )
 
-const (
-	tftpPort       = 69
-	maxRetransmits = 5
-)
+// DefaultPort is the well-known TFTP server port.
+const DefaultPort = 69
+
+// maxPacketSize fits a DATA packet with the largest block size, 65464 bytes.
+const maxPacketSize = 65468
 
 type opCode uint16
 
@@ -37,6 +39,8 @@ func (op opCode) String() string {
 		return "Ack"
 	case opError:
 		return "Error"
+	case opOAck:
+		return "OAck"
 	}
 	return ""
 }
@@ -56,11 +60,11 @@ const (
 	errOptionsDenied    tftpError = 8
 )
 
-// TFTP transfer modes, for completeness. All connections are treated as octet mode.
+// TFTP transfer modes. Mail mode is obsolete and treated as octet mode.
 const (
-	modeNetascii = "netascii"
-	modeOctet    = "octet"
-	modeMail     = "mail"
+	ModeNetascii = "netascii"
+	ModeOctet    = "octet"
+	ModeMail     = "mail"
 )
 
 // TFTP options
@@ -69,14 +73,27 @@ const (
 	optionTimeout      = "timeout"
 	optionTransferSize = "tsize"
 	optionWindowSize   = "windowsize"
+	optionRollover     = "rollover" // De-facto option, not part of any RFC
 )
 
 type tftpOptions struct {
 	oackSent   bool
 	blockSize  int
 	timeout    time.Duration
+	timeoutSet bool // The timeout option was negotiated
 	windowSize int
 	tsize      int64
+	rollover   int // -1 if not set, the receiver will detect which the sender uses
+}
+
+// Options are the options negotiated for a transfer.
+type Options struct {
+	BlockSize  int
+	Timeout    time.Duration
+	WindowSize int
+	// TransferSize is the size given by the client with the tsize option, or
+	// -1 if none was given.
+	TransferSize int64
 }
 
 // defaultOptions should never be changed at runtime. These settings comply
@@ -86,6 +103,7 @@ var defaultOptions = &tftpOptions{
 	timeout:    5 * time.Second,
 	windowSize: 1,
 	tsize:      -1,
+	rollover:   -1,
 }
 
 func (o *tftpOptions) copy() *tftpOptions {
@@ -93,11 +111,33 @@ func (o *tftpOptions) copy() *tftpOptions {
 		oackSent:   o.oackSent,
 		blockSize:  o.blockSize,
 		timeout:    o.timeout,
+		timeoutSet: o.timeoutSet,
 		windowSize: o.windowSize,
 		tsize:      o.tsize,
+		rollover:   o.rollover,
 	}
 }
 
+func (o *tftpOptions) public() Options {
+	return Options{
+		BlockSize:    o.blockSize,
+		Timeout:      o.timeout,
+		WindowSize:   o.windowSize,
+		TransferSize: o.tsize,
+	}
+}
+
+// logAttr returns the options as a group of log attributes.
+func (o *tftpOptions) logAttr() slog.Attr {
+	return slog.Group("options",
+		"blksize", o.blockSize,
+		"windowsize", o.windowSize,
+		"timeout", o.timeout,
+		"tsize", o.tsize,
+		"rollover", o.rollover,
+	)
+}
+
 func (o *tftpOptions) toMap() map[string]string {
 	r := make(map[string]string)
 
@@ -110,9 +150,12 @@ func (o *tftpOptions) toMap() map[string]string {
 	if o.tsize > -1 {
 		r[optionTransferSize] = strconv.FormatInt(o.tsize, 10)
 	}
-	if o.windowSize > -1 {
+	if o.windowSize != defaultOptions.windowSize {
 		r[optionWindowSize] = strconv.Itoa(o.windowSize)
 	}
+	if o.rollover > -1 {
+		r[optionRollover] = strconv.Itoa(o.rollover)
+	}
 
 	return r
 }
@@ -0,0 +1,181 @@
+package tftp
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// muxQueueLen is how many packets a single port transfer can have waiting to
+// be read, further packets are dropped like a full socket buffer would.
+const muxQueueLen = 256
+
+// demux routes packets arriving on a server socket to the transfers running
+// over it in single port mode. Transfers are told apart by the client's
+// address and port.
+type demux struct {
+	conn      net.PacketConn
+	mu        sync.Mutex
+	transfers map[string]*muxConn
+}
+
+func newDemux(conn net.PacketConn) *demux {
+	return &demux{conn: conn, transfers: make(map[string]*muxConn)}
+}
+
+// open returns a connection for a transfer with the client at addr, nil if
+// there already is one.
+func (d *demux) open(addr net.Addr) *muxConn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := addr.String()
+	if _, ok := d.transfers[key]; ok {
+		return nil
+	}
+	c := &muxConn{
+		demux:    d,
+		addr:     addr,
+		packets:  make(chan []byte, muxQueueLen),
+		closed:   make(chan struct{}),
+		deadline: make(chan struct{}),
+	}
+	d.transfers[key] = c
+	return c
+}
+
+// deliver queues the packet for the transfer with the client at addr. It
+// returns false if there's no such transfer. Repeated requests are dropped,
+// the transfer they asked for is already running.
+func (d *demux) deliver(addr net.Addr, packet []byte) bool {
+	d.mu.Lock()
+	c, ok := d.transfers[addr.String()]
+	d.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	if op := opCode(decodeUInt16(packet[:2])); op == opRead || op == opWrite {
+		return true
+	}
+
+	select {
+	case c.packets <- append([]byte(nil), packet...):
+	default:
+	}
+	return true
+}
+
+func (d *demux) remove(c *muxConn) {
+	d.mu.Lock()
+	if d.transfers[c.addr.String()] == c {
+		delete(d.transfers, c.addr.String())
+	}
+	d.mu.Unlock()
+}
+
+// muxConn is a net.PacketConn for a single port transfer. It writes to the
+// server socket and reads the packets the demux routes to it.
+type muxConn struct {
+	demux   *demux
+	addr    net.Addr
+	packets chan []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	mu           sync.Mutex
+	readDeadline time.Time
+	deadline     chan struct{} // Closed when the read deadline changes
+}
+
+func (c *muxConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		readDeadline, changed := c.readDeadline, c.deadline
+		c.mu.Unlock()
+
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if !readDeadline.IsZero() {
+			wait := time.Until(readDeadline)
+			if wait <= 0 {
+				return 0, nil, c.opError("read", os.ErrDeadlineExceeded)
+			}
+			timer = time.NewTimer(wait)
+			expired = timer.C
+		}
+
+		var n int
+		var err error
+		select {
+		case packet := <-c.packets:
+			n = copy(b, packet)
+		case <-expired:
+			err = c.opError("read", os.ErrDeadlineExceeded)
+		case <-c.closed:
+			err = c.opError("read", net.ErrClosed)
+		case <-changed:
+			// Wait again with the new deadline
+			if timer != nil {
+				timer.Stop()
+			}
+			continue
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		return n, c.addr, nil
+	}
+}
+
+func (c *muxConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, c.opError("write", net.ErrClosed)
+	default:
+	}
+	return c.demux.conn.WriteTo(b, addr)
+}
+
+// Close stops routing packets to the transfer, the server socket stays open.
+func (c *muxConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.demux.remove(c)
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *muxConn) LocalAddr() net.Addr {
+	return c.demux.conn.LocalAddr()
+}
+
+func (c *muxConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *muxConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	close(c.deadline)
+	c.deadline = make(chan struct{})
+	c.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline does nothing, writes go straight to the server socket.
+func (c *muxConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// opError wraps err like the errors of a real socket, which callers expect to
+// be a net.Error.
+func (c *muxConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "udp", Source: c.LocalAddr(), Addr: c.addr, Err: err}
+}
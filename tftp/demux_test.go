@@ -0,0 +1,182 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSinglePortRoundTrip(t *testing.T) {
+	root, addr := startTestServer(t, WithSinglePort)
+
+	for _, test := range roundTripTests {
+		t.Run(test.name, func(t *testing.T) {
+			data := randomBytes(test.size)
+			client := NewClient(addr, test.options...)
+
+			if err := client.Put(test.name, bytes.NewReader(data)); err != nil {
+				t.Fatalf("put: %s", err)
+			}
+			stored, err := os.ReadFile(filepath.Join(root, test.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(stored, data) {
+				t.Fatalf("put: stored %d bytes, expected %d", len(stored), len(data))
+			}
+
+			var buf bytes.Buffer
+			if err := client.Get(test.name, &buf); err != nil {
+				t.Fatalf("get: %s", err)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Fatalf("get: received %d bytes, expected %d", buf.Len(), len(data))
+			}
+		})
+	}
+}
+
+func TestSinglePortRepliesFromServerPort(t *testing.T) {
+	root, addr := startTestServer(t, WithSinglePort)
+	if err := os.WriteFile(filepath.Join(root, "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := &requestConn{conn: conn, addr: server}
+	client.sendReadRequest("file", ModeOctet, nil)
+	// A retransmitted request must not start a second transfer
+	client.sendReadRequest("file", ModeOctet, nil)
+
+	buffer := make([]byte, 516)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.String() != addr {
+		t.Errorf("expected DATA from %s, got it from %s", addr, from)
+	}
+	if !bytes.Equal(buffer[:n], []byte{0, byte(opData), 0, 1, 'd', 'a', 't', 'a'}) {
+		t.Fatalf("unexpected packet %v", buffer[:n])
+	}
+	client.sendAck(1)
+
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, _, err := conn.ReadFrom(buffer); err == nil {
+		t.Errorf("unexpected packet %v after the transfer completed", buffer[:n])
+	}
+}
+
+func TestSinglePortShutdownDrains(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewMemoryBackend()
+	data := randomBytes(20000)
+	backend.WriteFile("slow", data)
+	s := NewServer(WithBackend(backend), WithSinglePort, WithRateLimit(40000), WithShutdownTimeout(5*time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	go func() {
+		s.Serve(ctx, conn)
+		close(served)
+	}()
+
+	var buf bytes.Buffer
+	got := make(chan error, 1)
+	go func() {
+		got <- NewClient(conn.LocalAddr().String()).Get("slow", &buf)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	// The transfer's packets still arrive on the server socket while draining
+	if err := <-got; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("received %d bytes, expected %d", buf.Len(), len(data))
+	}
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return after the transfer finished")
+	}
+}
+
+func TestMuxConnDeadline(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	d := newDemux(conn)
+	peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+	c := d.open(peer)
+	if d.open(peer) != nil {
+		t.Fatal("opened a second transfer for the same peer")
+	}
+
+	c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, _, err = c.ReadFrom(make([]byte, 4))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	// Moving the deadline interrupts a blocked read
+	c.SetReadDeadline(time.Time{})
+	read := make(chan error, 1)
+	go func() {
+		_, _, err := c.ReadFrom(make([]byte, 4))
+		read <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.SetReadDeadline(time.Now())
+	select {
+	case err := <-read:
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("expected a timeout, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read not interrupted")
+	}
+
+	d.deliver(peer, []byte{0, 1, 'f', 0, 'o', 'c', 't', 'e', 't', 0})
+	if !d.deliver(peer, []byte{0, 4, 0, 1}) {
+		t.Fatal("packet not delivered")
+	}
+	c.SetReadDeadline(time.Time{})
+	buffer := make([]byte, 4)
+	if n, from, err := c.ReadFrom(buffer); err != nil || n != 4 || buffer[1] != byte(opAck) || from != peer {
+		t.Errorf("got %v from %v, %v", buffer[:n], from, err)
+	}
+
+	c.Close()
+	if d.deliver(peer, []byte{0, 4, 0, 2}) {
+		t.Error("packet delivered after close")
+	}
+	if d.open(peer) == nil {
+		t.Error("peer not released after close")
+	}
+}
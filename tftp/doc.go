@@ -0,0 +1,3 @@
+// Package tftp implements a TFTP server and client supporting RFC 1350 and
+// the option extensions from RFC 2347, 2348 and 2349.
+package tftp
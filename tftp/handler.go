@@ -0,0 +1,29 @@
+package tftp
+
+import (
+	"io"
+	"net"
+)
+
+// ReadRequest describes a read request from a client.
+type ReadRequest struct {
+	// Filename is the requested path, cleaned and relative to the server root.
+	Filename string
+	// Addr is the address of the client.
+	Addr net.Addr
+	// Mode is the transfer mode, ModeOctet or ModeNetascii.
+	Mode string
+	// Options are the options negotiated with the client.
+	Options Options
+}
+
+// ReadHandler generates the content for a read request. It returns the
+// content and its size, or -1 if the size isn't known in which case the
+// tsize option won't be acknowledged. If the reader is also an io.Closer it's
+// closed when the transfer ends.
+//
+// A handler returns a nil reader and nil error to pass the request on to the
+// next handler, or the backend if it's the last one. An error wrapping
+// fs.ErrNotExist is sent to the client as file not found, any other error as
+// an access violation.
+type ReadHandler func(req *ReadRequest) (io.Reader, int64, error)
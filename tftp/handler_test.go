@@ -0,0 +1,57 @@
+package tftp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadHandler(t *testing.T) {
+	var seen *ReadRequest
+	handler := func(req *ReadRequest) (io.Reader, int64, error) {
+		switch req.Filename {
+		case "boot.cfg":
+			seen = req
+			content := fmt.Sprintf("ip=%s\n", req.Addr.(*net.UDPAddr).IP)
+			return bytes.NewReader([]byte(content)), int64(len(content)), nil
+		case "denied":
+			return nil, -1, fs.ErrNotExist
+		}
+		return nil, -1, nil
+	}
+
+	root, addr := startTestServer(t, WithReadHandler(handler))
+	if err := os.WriteFile(filepath.Join(root, "static"), []byte("static"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(addr, WithClientBlockSize(1024))
+
+	var buf bytes.Buffer
+	if err := client.Get("boot.cfg", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "ip=127.0.0.1\n" {
+		t.Errorf("expected generated content, got %q", buf.String())
+	}
+	if seen.Mode != ModeOctet || seen.Options.BlockSize != 1024 {
+		t.Errorf("unexpected request %#v", seen)
+	}
+
+	buf.Reset()
+	if err := client.Get("static", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "static" {
+		t.Errorf("expected static file, got %q", buf.String())
+	}
+
+	err := client.Get("denied", &buf)
+	if remoteErr, ok := err.(*RemoteError); !ok || remoteErr.Code != uint16(errFileNotFound) {
+		t.Errorf("expected file not found, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package tftp
+
+import (
+	"net"
+	"time"
+)
+
+// TransferOp is the request a transfer was started with.
+type TransferOp int
+
+const (
+	// TransferRead is a read request, the server sends the file.
+	TransferRead TransferOp = iota + 1
+	// TransferWrite is a write request, the client sends the file.
+	TransferWrite
+)
+
+func (op TransferOp) String() string {
+	switch op {
+	case TransferRead:
+		return "read"
+	case TransferWrite:
+		return "write"
+	}
+	return ""
+}
+
+// Transfer describes a transfer to hooks.
+type Transfer struct {
+	// ID is unique within the process and matches the transfer attribute of
+	// log lines.
+	ID       uint64
+	Peer     net.Addr
+	Filename string
+	Op       TransferOp
+	Options  Options
+	// Bytes is the amount of file data that reached the receiver so far.
+	Bytes    int64
+	Duration time.Duration
+	// Err is why the transfer failed, only set for the Fail hook.
+	Err error
+}
+
+// Hooks are called during the lifecycle of transfers. Any of them may be nil.
+// They're called from the goroutine running the transfer, which waits for them
+// to return.
+type Hooks struct {
+	// Start is called once the options are negotiated, before any data is
+	// acknowledged.
+	Start func(Transfer)
+	// Progress is called each time the receiver acknowledges a window of
+	// blocks.
+	Progress func(Transfer)
+	// Complete is called after the last block is acknowledged.
+	Complete func(Transfer)
+	// Fail is called when a transfer ends without completing. For clients
+	// that includes the server rejecting the request, in which case Start was
+	// never called.
+	Fail func(Transfer)
+}
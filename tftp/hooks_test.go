@@ -0,0 +1,124 @@
+package tftp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookRecorder records the hooks called for transfers.
+type hookRecorder struct {
+	mu     sync.Mutex
+	events []string
+	last   Transfer
+	done   chan struct{}
+}
+
+func newHookRecorder() *hookRecorder {
+	return &hookRecorder{done: make(chan struct{}, 1)}
+}
+
+func (r *hookRecorder) hooks() Hooks {
+	record := func(event string) func(Transfer) {
+		return func(t Transfer) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if len(r.events) == 0 || r.events[len(r.events)-1] != event {
+				r.events = append(r.events, event)
+			}
+			r.last = t
+			if event == "complete" || event == "fail" {
+				r.done <- struct{}{}
+			}
+		}
+	}
+	return Hooks{
+		Start:    record("start"),
+		Progress: record("progress"),
+		Complete: record("complete"),
+		Fail:     record("fail"),
+	}
+}
+
+// wait waits for a transfer to complete or fail and returns the hooks called.
+func (r *hookRecorder) wait(t *testing.T) ([]string, Transfer) {
+	t.Helper()
+
+	select {
+	case <-r.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("transfer didn't finish")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.events, r.last
+}
+
+func TestServerHooks(t *testing.T) {
+	recorder := newHookRecorder()
+	root, addr := startTestServer(t, WithHooks(recorder.hooks()))
+	data := randomBytes(10000)
+	if err := os.WriteFile(filepath.Join(root, "file"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewClient(addr, WithClientBlockSize(1024)).Get("file", &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	events, last := recorder.wait(t)
+	expected := []string{"start", "progress", "complete"}
+	if !equalStrings(events, expected) {
+		t.Errorf("expected hooks %v, got %v", expected, events)
+	}
+	if last.Op != TransferRead || last.Filename != "file" || last.Bytes != int64(len(data)) || last.Options.BlockSize != 1024 {
+		t.Errorf("unexpected transfer %+v", last)
+	}
+	if last.ID == 0 || last.Peer == nil || last.Err != nil {
+		t.Errorf("unexpected transfer %+v", last)
+	}
+}
+
+func TestClientHooks(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	recorder := newHookRecorder()
+	client := NewClient(addr, WithClientHooks(recorder.hooks()))
+
+	data := randomBytes(5000)
+	if err := client.Put("file", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	events, last := recorder.wait(t)
+	if expected := []string{"start", "progress", "complete"}; !equalStrings(events, expected) {
+		t.Errorf("expected hooks %v, got %v", expected, events)
+	}
+	if last.Op != TransferWrite || last.Bytes != int64(len(data)) {
+		t.Errorf("unexpected transfer %+v", last)
+	}
+
+	recorder.events = nil
+	client.Get("missing", &bytes.Buffer{})
+	events, last = recorder.wait(t)
+	if expected := []string{"fail"}; !equalStrings(events, expected) {
+		t.Errorf("expected hooks %v, got %v", expected, events)
+	}
+	if _, ok := last.Err.(*RemoteError); !ok || last.Op != TransferRead {
+		t.Errorf("expected a failed read with a RemoteError, got %+v", last)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,233 @@
+package tftp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request outcomes counted by Metrics.
+const (
+	outcomeCompleted = "completed" // Transfer finished
+	outcomeFailed    = "failed"    // Transfer started but didn't finish
+	outcomeInvalid   = "invalid"   // Malformed request or unsupported mode
+	outcomeDenied    = "denied"    // Refused by the path, permissions or ACLs
+	outcomeBusy      = "busy"      // Over the transfer limits
+	outcomeNotFound  = "not_found" // File doesn't exist
+	outcomeExists    = "exists"    // Upload would overwrite a file
+	outcomeError     = "error"     // Opening the file or setting up the transfer failed
+)
+
+// Metrics collects server statistics and serves them in the Prometheus text
+// exposition format. Create it with NewMetrics and add it to a server with
+// WithMetrics. A nil *Metrics discards everything.
+type Metrics struct {
+	mu            sync.Mutex
+	requests      map[requestKey]uint64
+	active        int64
+	bytesSent     uint64
+	bytesReceived uint64
+	retransmits   uint64
+	timeouts      uint64
+	errorsSent    map[tftpError]uint64
+	blockSize     *histogram
+	windowSize    *histogram
+	duration      *histogram
+}
+
+type requestKey struct {
+	op      opCode
+	outcome string
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:   make(map[requestKey]uint64),
+		errorsSent: make(map[tftpError]uint64),
+		blockSize:  newHistogram(512, 1024, 1428, 2048, 4096, 8192, 16384, 32768, 65464),
+		windowSize: newHistogram(1, 2, 4, 8, 16, 32, 64),
+		duration:   newHistogram(0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300),
+	}
+}
+
+func (m *Metrics) request(op opCode, outcome string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.requests[requestKey{op: op, outcome: outcome}]++
+	m.mu.Unlock()
+}
+
+// transferStarted records a transfer starting with the negotiated options.
+func (m *Metrics) transferStarted(options *tftpOptions) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.active++
+	m.blockSize.observe(float64(options.blockSize))
+	m.windowSize.observe(float64(options.windowSize))
+	m.mu.Unlock()
+}
+
+func (m *Metrics) transferFinished(duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.active--
+	m.duration.observe(duration.Seconds())
+	m.mu.Unlock()
+}
+
+func (m *Metrics) sent(n int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.bytesSent += uint64(n)
+	m.mu.Unlock()
+}
+
+func (m *Metrics) received(n int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.bytesReceived += uint64(n)
+	m.mu.Unlock()
+}
+
+func (m *Metrics) retransmit() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.retransmits++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) timeout() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.timeouts++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) errorSent(code tftpError) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.errorsSent[code]++
+	m.mu.Unlock()
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
+
+// WritePrometheus writes the metrics to w in the Prometheus text exposition
+// format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := bufio.NewWriter(w)
+
+	writeHeader(b, "tftp_requests_total", "counter", "Requests received by opcode and outcome.")
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].op != keys[j].op {
+			return keys[i].op < keys[j].op
+		}
+		return keys[i].outcome < keys[j].outcome
+	})
+	for _, key := range keys {
+		fmt.Fprintf(b, "tftp_requests_total{op=%q,outcome=%q} %d\n", strings.ToLower(key.op.String()), key.outcome, m.requests[key])
+	}
+
+	writeHeader(b, "tftp_active_transfers", "gauge", "Transfers in progress.")
+	fmt.Fprintf(b, "tftp_active_transfers %d\n", m.active)
+
+	writeHeader(b, "tftp_sent_bytes_total", "counter", "DATA payload bytes sent, including retransmits.")
+	fmt.Fprintf(b, "tftp_sent_bytes_total %d\n", m.bytesSent)
+	writeHeader(b, "tftp_received_bytes_total", "counter", "DATA payload bytes received in order.")
+	fmt.Fprintf(b, "tftp_received_bytes_total %d\n", m.bytesReceived)
+
+	writeHeader(b, "tftp_retransmits_total", "counter", "Packets sent again after a timeout or lost block.")
+	fmt.Fprintf(b, "tftp_retransmits_total %d\n", m.retransmits)
+	writeHeader(b, "tftp_timeouts_total", "counter", "Reads timing out while waiting for the peer.")
+	fmt.Fprintf(b, "tftp_timeouts_total %d\n", m.timeouts)
+
+	writeHeader(b, "tftp_errors_sent_total", "counter", "ERROR packets sent by error code.")
+	codes := make([]tftpError, 0, len(m.errorsSent))
+	for code := range m.errorsSent {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	for _, code := range codes {
+		fmt.Fprintf(b, "tftp_errors_sent_total{code=\"%d\"} %d\n", code, m.errorsSent[code])
+	}
+
+	m.blockSize.write(b, "tftp_negotiated_blocksize", "Block size of started transfers.")
+	m.windowSize.write(b, "tftp_negotiated_windowsize", "Window size of started transfers.")
+	m.duration.write(b, "tftp_transfer_duration_seconds", "Duration of finished transfers.")
+
+	return b.Flush()
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// histogram counts observations in buckets with the given upper bounds.
+type histogram struct {
+	bounds []float64
+	counts []uint64 // Per bucket, the last one is +Inf
+	sum    float64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	writeHeader(w, name, "histogram", help)
+
+	var total uint64
+	for i, count := range h.counts {
+		total += count
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, le, total)
+	}
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, total)
+}
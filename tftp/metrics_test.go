@@ -0,0 +1,70 @@
+package tftp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	root, addr := startTestServer(t, WithMetrics(metrics), WithDisableCreate)
+	data := randomBytes(5000)
+	if err := os.WriteFile(filepath.Join(root, "file"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(addr, WithClientBlockSize(1024))
+	if err := client.Get("file", &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	client.Get("missing", &bytes.Buffer{})
+	client.Put("new", bytes.NewReader(data))
+
+	var buf bytes.Buffer
+	if err := metrics.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	expected := []string{
+		`tftp_requests_total{op="read",outcome="completed"} 1`,
+		`tftp_requests_total{op="read",outcome="not_found"} 1`,
+		`tftp_requests_total{op="write",outcome="denied"} 1`,
+		`tftp_sent_bytes_total 5000`,
+		`tftp_errors_sent_total{code="1"} 1`,
+		`tftp_errors_sent_total{code="2"} 1`,
+		`tftp_negotiated_blocksize_bucket{le="512"} 0`,
+		`tftp_negotiated_blocksize_bucket{le="1024"} 1`,
+		`tftp_negotiated_blocksize_count 1`,
+		`tftp_negotiated_windowsize_bucket{le="1"} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram(1, 10)
+	for _, v := range []float64{0.5, 1, 5, 20} {
+		h.observe(v)
+	}
+
+	var buf bytes.Buffer
+	h.write(&buf, "test", "Test histogram.")
+	expected := `# HELP test Test histogram.
+# TYPE test histogram
+test_bucket{le="1"} 2
+test_bucket{le="10"} 3
+test_bucket{le="+Inf"} 4
+test_sum 26.5
+test_count 4
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
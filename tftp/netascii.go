@@ -0,0 +1,114 @@
+package tftp
+
+import (
+	"bufio"
+	"io"
+)
+
+// netasciiReader translates local text to netascii, LF becomes CR LF and a
+// bare CR becomes CR NUL. Expanded pairs may be split across reads so blocks
+// read with io.ReadFull are always full sized.
+type netasciiReader struct {
+	r          *bufio.Reader
+	pending    byte
+	hasPending bool
+	err        error
+}
+
+func newNetasciiReader(r io.Reader) *netasciiReader {
+	return &netasciiReader{r: bufio.NewReader(r)}
+}
+
+func (n *netasciiReader) Read(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		if n.hasPending {
+			p[written] = n.pending
+			n.hasPending = false
+			written++
+			continue
+		}
+
+		if n.err != nil {
+			break
+		}
+
+		c, err := n.r.ReadByte()
+		if err != nil {
+			n.err = err
+			break
+		}
+
+		switch c {
+		case '\n':
+			p[written] = '\r'
+			n.pending = '\n'
+			n.hasPending = true
+		case '\r':
+			p[written] = '\r'
+			n.pending = 0
+			n.hasPending = true
+		default:
+			p[written] = c
+		}
+		written++
+	}
+
+	if written > 0 {
+		return written, nil
+	}
+	return 0, n.err
+}
+
+// netasciiWriter translates netascii to local text, CR LF becomes LF and
+// CR NUL becomes CR. A CR at the end of a write is held until the next byte
+// arrives, Flush writes it out at the end of a transfer.
+type netasciiWriter struct {
+	w   io.Writer
+	cr  bool
+	buf []byte
+}
+
+func newNetasciiWriter(w io.Writer) *netasciiWriter {
+	return &netasciiWriter{w: w}
+}
+
+func (n *netasciiWriter) Write(p []byte) (int, error) {
+	out := n.buf[:0]
+	for _, c := range p {
+		if n.cr {
+			n.cr = false
+			switch c {
+			case '\n':
+				out = append(out, '\n')
+				continue
+			case 0:
+				out = append(out, '\r')
+				continue
+			default: // Bare CR isn't valid netascii, pass it through
+				out = append(out, '\r')
+			}
+		}
+
+		if c == '\r' {
+			n.cr = true
+			continue
+		}
+		out = append(out, c)
+	}
+	n.buf = out
+
+	if _, err := n.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (n *netasciiWriter) Flush() error {
+	if !n.cr {
+		return nil
+	}
+	n.cr = false
+	_, err := n.w.Write([]byte{'\r'})
+	return err
+}
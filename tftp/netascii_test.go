@@ -0,0 +1,60 @@
+package tftp
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+var netasciiTests = []struct {
+	local    string
+	netascii string
+}{
+	{local: "", netascii: ""},
+	{local: "hello", netascii: "hello"},
+	{local: "hello\n", netascii: "hello\r\n"},
+	{local: "a\nb\n\nc", netascii: "a\r\nb\r\n\r\nc"},
+	{local: "a\rb", netascii: "a\r\x00b"},
+	{local: "\r\n", netascii: "\r\x00\r\n"},
+	{local: "end\r", netascii: "end\r\x00"},
+}
+
+func TestNetasciiReader(t *testing.T) {
+	for _, test := range netasciiTests {
+		// Read in 3 byte blocks to split expanded pairs
+		r := newNetasciiReader(bytes.NewReader([]byte(test.local)))
+		var out []byte
+		block := make([]byte, 3)
+		for {
+			n, err := io.ReadFull(r, block)
+			out = append(out, block[:n]...)
+			if err != nil {
+				break
+			}
+		}
+
+		if string(out) != test.netascii {
+			t.Errorf("encode %q: expected %q, got %q", test.local, test.netascii, out)
+		}
+	}
+}
+
+func TestNetasciiWriter(t *testing.T) {
+	for _, test := range netasciiTests {
+		var buf bytes.Buffer
+		w := newNetasciiWriter(&buf)
+		// Write one byte at a time to split pairs across writes
+		for i := 0; i < len(test.netascii); i++ {
+			if _, err := w.Write([]byte{test.netascii[i]}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.String() != test.local {
+			t.Errorf("decode %q: expected %q, got %q", test.netascii, test.local, buf.String())
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package tftp
+
+import "strings"
+
+// Permissions control what clients may do with files.
+type Permissions struct {
+	DisableRead    bool
+	DisableWrite   bool
+	DisableCreate  bool
+	AllowOverwrite bool
+}
+
+// PathRule sets the permissions for a path and everything below it, replacing
+// the server-wide permissions.
+type PathRule struct {
+	Prefix string
+	Permissions
+}
+
+// pathRules are ordered by prefix length so the most specific rule matches
+// first.
+type pathRules []PathRule
+
+func (rules pathRules) add(rule PathRule) pathRules {
+	rule.Prefix = strings.Trim(rule.Prefix, "/")
+	for i, r := range rules {
+		if len(rule.Prefix) > len(r.Prefix) {
+			return append(rules[:i], append(pathRules{rule}, rules[i:]...)...)
+		}
+	}
+	return append(rules, rule)
+}
+
+// permissions returns the permissions of the most specific rule matching
+// name, or defaults if none match.
+func (rules pathRules) permissions(name string, defaults Permissions) Permissions {
+	for _, rule := range rules {
+		if pathHasPrefix(name, rule.Prefix) {
+			return rule.Permissions
+		}
+	}
+	return defaults
+}
@@ -0,0 +1,54 @@
+package tftp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPathRules(t *testing.T) {
+	var rules pathRules
+	rules = rules.add(PathRule{Prefix: "/uploads/", Permissions: Permissions{AllowOverwrite: true}})
+	rules = rules.add(PathRule{Prefix: "firmware", Permissions: Permissions{DisableWrite: true}})
+	rules = rules.add(PathRule{Prefix: "uploads/locked", Permissions: Permissions{DisableRead: true, DisableWrite: true}})
+
+	defaults := Permissions{DisableCreate: true}
+	tests := []struct {
+		path     string
+		expected Permissions
+	}{
+		{path: "boot", expected: defaults},
+		{path: "firmware", expected: Permissions{DisableWrite: true}},
+		{path: "firmware/switch.bin", expected: Permissions{DisableWrite: true}},
+		{path: "firmware2", expected: defaults},
+		{path: "uploads/file", expected: Permissions{AllowOverwrite: true}},
+		{path: "uploads/locked/file", expected: Permissions{DisableRead: true, DisableWrite: true}},
+	}
+
+	for _, test := range tests {
+		if got := rules.permissions(test.path, defaults); got != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.path, test.expected, got)
+		}
+	}
+}
+
+func TestServerPathRules(t *testing.T) {
+	root, addr := startTestServer(t,
+		WithDisableWrite,
+		WithPathRule(PathRule{Prefix: "uploads"}),
+	)
+	if err := os.Mkdir(filepath.Join(root, "uploads"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(addr)
+
+	if err := client.Put("uploads/file", bytes.NewReader([]byte("data"))); err != nil {
+		t.Errorf("put under uploads: %s", err)
+	}
+
+	err := client.Put("file", bytes.NewReader([]byte("data")))
+	if remoteErr, ok := err.(*RemoteError); !ok || remoteErr.Code != uint16(errAccessViolation) {
+		t.Errorf("expected access violation, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"syscall"
+)
+
+var errPortsExhausted = errors.New("no free port in the transfer port range")
+
+// portRange is the ports transfer sockets may use, any free port if it's zero.
+type portRange struct {
+	low, high int
+}
+
+// listenTransfer opens a socket for a transfer requested on the server socket
+// at local. The socket has the same address family and, unless local is a
+// wildcard address, the same IP so the client gets replies from the address
+// it sent its request to.
+func listenTransfer(local net.Addr, ports portRange) (net.PacketConn, error) {
+	network, ip, zone := "udp", net.IP(nil), ""
+	if addr, ok := local.(*net.UDPAddr); ok && addr.IP != nil && !(addr.IP.IsUnspecified() && addr.IP.To4() == nil) {
+		// Anything but the dual-stack wildcard
+		network, ip, zone = "udp6", addr.IP, addr.Zone
+		if addr.IP.To4() != nil {
+			network = "udp4"
+		}
+	}
+
+	if ports == (portRange{}) {
+		return listenUDP(network, &net.UDPAddr{IP: ip, Zone: zone})
+	}
+	if ports.low < 1 || ports.high > 65535 || ports.low > ports.high {
+		return nil, fmt.Errorf("invalid transfer port range %d-%d", ports.low, ports.high)
+	}
+
+	// Start at a random port so transfers don't all race for the lowest ones
+	size := ports.high - ports.low + 1
+	start := rand.Intn(size)
+	for i := 0; i < size; i++ {
+		port := ports.low + (start+i)%size
+		conn, err := listenUDP(network, &net.UDPAddr{IP: ip, Port: port, Zone: zone})
+		if errors.Is(err, syscall.EADDRINUSE) {
+			continue
+		}
+		return conn, err
+	}
+	return nil, errPortsExhausted
+}
+
+// listenUDP is net.ListenUDP returning a nil interface on errors.
+func listenUDP(network string, addr *net.UDPAddr) (net.PacketConn, error) {
+	conn, err := net.ListenUDP(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
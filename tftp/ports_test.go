@@ -0,0 +1,97 @@
+package tftp
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenTransfer(t *testing.T) {
+	tests := []struct {
+		local   string
+		network string
+		ip      string
+	}{
+		{local: "127.0.0.1:69", network: "udp4", ip: "127.0.0.1"},
+		{local: "0.0.0.0:69", network: "udp4", ip: "0.0.0.0"},
+		{local: "[::1]:69", network: "udp6", ip: "::1"},
+		{local: "[::]:69", network: "udp", ip: "::"},
+	}
+
+	for _, test := range tests {
+		local, err := net.ResolveUDPAddr(test.network, test.local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := listenTransfer(local, portRange{})
+		if err != nil {
+			if test.network == "udp6" {
+				continue // No IPv6 loopback
+			}
+			t.Fatalf("%s: %s", test.local, err)
+		}
+		addr := conn.LocalAddr().(*net.UDPAddr)
+		conn.Close()
+
+		if !addr.IP.Equal(net.ParseIP(test.ip)) || addr.Port == 0 || addr.Port == 69 {
+			t.Errorf("%s: transfer socket bound to %s", test.local, addr)
+		}
+	}
+}
+
+func TestListenTransferPortRange(t *testing.T) {
+	local := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	ports := portRange{low: 50000, high: 50002}
+
+	var conns []net.PacketConn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	seen := make(map[int]bool)
+	for i := 0; i < 3; i++ {
+		conn, err := listenTransfer(local, ports)
+		if err != nil {
+			t.Skipf("port range in use: %s", err)
+		}
+		conns = append(conns, conn)
+
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		if port < ports.low || port > ports.high || seen[port] {
+			t.Errorf("unexpected port %d", port)
+		}
+		seen[port] = true
+	}
+
+	if _, err := listenTransfer(local, ports); err != errPortsExhausted {
+		t.Errorf("expected the range to be exhausted, got %v", err)
+	}
+	if _, err := listenTransfer(local, portRange{low: 2, high: 1}); err == nil {
+		t.Error("expected an error for an invalid range")
+	}
+}
+
+func TestPortRangeExhausted(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:50010")
+	if err != nil {
+		t.Skipf("port in use: %s", err)
+	}
+	defer conn.Close()
+	root, addr := startTestServer(t, WithPortRange(50010, 50010))
+
+	err = NewClient(addr).Put("file", bytes.NewReader([]byte("data")))
+	remoteErr, ok := err.(*RemoteError)
+	if !ok {
+		t.Fatalf("expected RemoteError, got %v", err)
+	}
+	if remoteErr.Code != uint16(errNotDefined) {
+		t.Errorf("expected error code %d, got %d", errNotDefined, remoteErr.Code)
+	}
+	if _, err := os.Stat(filepath.Join(root, "file")); !os.IsNotExist(err) {
+		t.Errorf("expected the rejected upload not to be stored, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package tftp
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket limiting throughput to rate bytes per second.
+// It may be shared by several transfers. The bucket holds a tenth of a second
+// of tokens and can go into debt so packets larger than that still pass.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	rate := float64(bytesPerSec)
+	return &rateLimiter{
+		rate:   rate,
+		burst:  rate / 10,
+		tokens: rate / 10,
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until n bytes may be sent or done is closed.
+func (l *rateLimiter) wait(done <-chan struct{}, n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+		}
+	}
+}
+
+// networkRateLimit is a rate limit shared by all clients in a network.
+type networkRateLimit struct {
+	network *net.IPNet
+	limiter *rateLimiter
+}
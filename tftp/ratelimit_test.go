@@ -0,0 +1,52 @@
+package tftp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(100000)
+
+	start := time.Now()
+	for i := 0; i < 50; i++ {
+		l.wait(nil, 1000)
+	}
+
+	// 50 KB at 100 KB/s less the 10 KB burst
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected about 400ms, took %s", elapsed)
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []ServerOption
+	}{
+		{name: "transfer", options: []ServerOption{WithRateLimit(200000)}},
+		{name: "global", options: []ServerOption{WithGlobalRateLimit(200000)}},
+		{name: "network", options: []ServerOption{
+			WithNetworkRateLimit(&net.IPNet{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}, 1),
+			WithNetworkRateLimit(&net.IPNet{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}, 200000),
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := NewMemoryBackend()
+			backend.WriteFile("file", randomBytes(100000))
+			_, addr := startTestServer(t, append(test.options, WithBackend(backend))...)
+
+			start := time.Now()
+			if err := NewClient(addr, WithClientWindowSize(8)).Get("file", &bytes.Buffer{}); err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+				t.Errorf("expected transfer to take about 500ms, took %s", elapsed)
+			}
+		})
+	}
+}
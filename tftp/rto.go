@@ -0,0 +1,107 @@
+package tftp
+
+import "time"
+
+const (
+	// initialAdaptiveTimeout is the adaptive timeout before the round trip
+	// time has been measured, as recommended by RFC 6298.
+	initialAdaptiveTimeout = time.Second
+	// minAdaptiveTimeout keeps the adaptive timeout above the jitter of
+	// fast networks.
+	minAdaptiveTimeout = 200 * time.Millisecond
+)
+
+// retransmitConfig is how transfers wait for their peer, set with the server
+// and client options.
+type retransmitConfig struct {
+	retries    int           // Retransmits of a packet before giving up
+	adaptive   bool          // Derive the timeout from the round trip time
+	maxTimeout time.Duration // Ceiling of the adaptive timeout when backing off
+}
+
+var defaultRetransmit = retransmitConfig{
+	retries:    5,
+	maxTimeout: 30 * time.Second,
+}
+
+// rto tracks the retransmission timeout of a transfer. Without the adaptive
+// mode, or when a timeout was negotiated with the timeout option, the timeout
+// is fixed. Otherwise it's estimated from the round trip time like TCP does
+// (RFC 6298) and doubled after every timeout up to the ceiling.
+type rto struct {
+	config  retransmitConfig
+	fixed   bool
+	timeout time.Duration
+	srtt    time.Duration // Smoothed round trip time, 0 until measured
+	rttvar  time.Duration
+	retries int // Timeouts since the peer last answered
+}
+
+func newRTO(config retransmitConfig, options *tftpOptions) *rto {
+	r := &rto{config: config, timeout: initialAdaptiveTimeout}
+	if !config.adaptive || options.timeoutSet {
+		r.fixed, r.timeout = true, options.timeout
+	}
+	if !r.fixed && config.maxTimeout > 0 && r.timeout > config.maxTimeout {
+		r.timeout = config.maxTimeout
+	}
+	return r
+}
+
+// negotiated switches to the timeout from the peer's OACK if it has one.
+func (r *rto) negotiated(options *tftpOptions) {
+	if options.timeoutSet {
+		r.fixed, r.timeout = true, options.timeout
+	}
+}
+
+// current returns how long to wait for the peer.
+func (r *rto) current() time.Duration {
+	return r.timeout
+}
+
+// expired records a timeout and backs off. It returns false once the packet
+// has been retransmitted as often as configured, the transfer has failed then.
+func (r *rto) expired() bool {
+	r.retries++
+	if r.retries > r.config.retries {
+		return false
+	}
+	if !r.fixed {
+		r.timeout *= 2
+		if r.config.maxTimeout > 0 && r.timeout > r.config.maxTimeout {
+			r.timeout = r.config.maxTimeout
+		}
+	}
+	return true
+}
+
+// answered records the peer answering after rtt, 0 if the answer wasn't
+// timed. Answers to retransmitted packets aren't used either, they could
+// belong to any of the copies (Karn's algorithm).
+func (r *rto) answered(rtt time.Duration) {
+	measured := r.retries == 0 && rtt > 0
+	r.retries = 0
+	if r.fixed || !measured {
+		return
+	}
+
+	if r.srtt == 0 {
+		r.srtt, r.rttvar = rtt, rtt/2
+	} else {
+		delta := r.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		r.rttvar = (3*r.rttvar + delta) / 4
+		r.srtt = (7*r.srtt + rtt) / 8
+	}
+
+	r.timeout = r.srtt + 4*r.rttvar
+	if r.timeout < minAdaptiveTimeout {
+		r.timeout = minAdaptiveTimeout
+	}
+	if r.config.maxTimeout > 0 && r.timeout > r.config.maxTimeout {
+		r.timeout = r.config.maxTimeout
+	}
+}
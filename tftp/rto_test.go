@@ -0,0 +1,111 @@
+package tftp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRTOFixed(t *testing.T) {
+	r := newRTO(defaultRetransmit, defaultOptions)
+	r.answered(10 * time.Millisecond)
+	for i := 0; i < defaultRetransmit.retries; i++ {
+		if !r.expired() {
+			t.Fatalf("gave up after %d retries", i)
+		}
+		if r.current() != defaultOptions.timeout {
+			t.Fatalf("expected the fixed timeout %s, got %s", defaultOptions.timeout, r.current())
+		}
+	}
+	if r.expired() {
+		t.Error("expected to give up after the configured retries")
+	}
+}
+
+func TestRTOAdaptive(t *testing.T) {
+	config := retransmitConfig{retries: 5, adaptive: true, maxTimeout: 4 * time.Second}
+	r := newRTO(config, defaultOptions)
+	if r.current() != initialAdaptiveTimeout {
+		t.Fatalf("expected the initial timeout %s, got %s", initialAdaptiveTimeout, r.current())
+	}
+
+	// srtt 100ms and rttvar 50ms
+	r.answered(100 * time.Millisecond)
+	if r.current() != 300*time.Millisecond {
+		t.Fatalf("expected 300ms, got %s", r.current())
+	}
+
+	// Backing off doubles the timeout up to the ceiling
+	for _, expected := range []time.Duration{600, 1200, 2400, 4000, 4000} {
+		r.expired()
+		if r.current() != expected*time.Millisecond {
+			t.Fatalf("expected %dms, got %s", expected, r.current())
+		}
+	}
+
+	// The answer to a retransmit isn't timed, the backed off timeout stays
+	r.answered(100 * time.Millisecond)
+	if r.current() != 4*time.Second {
+		t.Fatalf("expected the timeout to stay at 4s, got %s", r.current())
+	}
+
+	// Fast networks are kept at the minimum
+	for i := 0; i < 50; i++ {
+		r.answered(time.Millisecond)
+	}
+	if r.current() != minAdaptiveTimeout {
+		t.Errorf("expected the minimum timeout %s, got %s", minAdaptiveTimeout, r.current())
+	}
+}
+
+func TestRTONegotiated(t *testing.T) {
+	config := retransmitConfig{retries: 5, adaptive: true, maxTimeout: time.Second}
+	options, _ := parseOptions([][]byte{[]byte("timeout"), []byte("3")})
+	if !options.timeoutSet {
+		t.Fatal("timeout option not marked as negotiated")
+	}
+
+	for _, r := range []*rto{newRTO(config, options), newRTO(config, defaultOptions)} {
+		r.negotiated(options)
+		r.answered(10 * time.Millisecond)
+		r.expired()
+		if r.current() != 3*time.Second {
+			t.Errorf("expected the negotiated timeout 3s, got %s", r.current())
+		}
+	}
+}
+
+func TestAdaptiveTimeoutRecoversQuickly(t *testing.T) {
+	root, addr := startTestServer(t, WithAdaptiveTimeout)
+	data := randomBytes(20 * 512)
+	if err := os.WriteFile(filepath.Join(root, "file"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lose the first copy of block 10
+	dropped := false
+	proxy := startTestProxy(t, addr, func(packet []byte, toClient bool) int {
+		if toClient && !dropped && decodeUInt16(packet[:2]) == uint16(opData) && decodeUInt16(packet[2:4]) == 10 {
+			dropped = true
+			return 0
+		}
+		return 1
+	})
+
+	start := time.Now()
+	var buf bytes.Buffer
+	if err := NewClient(proxy.front.LocalAddr().String(), WithClientRFC1350).Get("file", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("received %d bytes, expected %d", buf.Len(), len(data))
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("transfer took %s, expected the loss to be recovered from well before the fixed timeout", elapsed)
+	}
+	if proxy.retransmits() != 1 {
+		t.Errorf("expected block 10 to be sent again once, got %d retransmits", proxy.retransmits())
+	}
+}
@@ -0,0 +1,855 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerOption configures a Server.
+type ServerOption func(*Server)
+
+// Server is a TFTP server serving files from a Backend.
+type Server struct {
+	mu     sync.RWMutex
+	config *serverConfig
+	slots  *transferSlots
+}
+
+// serverConfig holds the settings of a Server. It's replaced as a whole on
+// Reload so each request sees a consistent configuration.
+type serverConfig struct {
+	backend         Backend
+	perms           Permissions
+	pathRules       pathRules
+	strict          bool
+	rfc1350         bool
+	logger          *slog.Logger
+	maxWindowSize   int
+	rollover        int
+	readHandlers    []ReadHandler
+	readACL         ACL
+	writeACL        ACL
+	rateLimit       int64
+	globalLimiter   *rateLimiter
+	networkLimits   []networkRateLimit
+	maxTransfers    int
+	maxPerClient    int
+	queueTimeout    time.Duration
+	shutdownTimeout time.Duration
+	metrics         *Metrics
+	hooks           Hooks
+	audit           *auditLog
+	ports           portRange
+	singlePort      bool
+	retransmit      retransmitConfig
+}
+
+// defaultShutdownTimeout is how long in-flight transfers may take to finish
+// once the server is shutting down.
+const defaultShutdownTimeout = 30 * time.Second
+
+// defaultMaxWindowSize bounds the number of blocks a transfer buffers for
+// retransmission.
+const defaultMaxWindowSize = 64
+
+// NewServer returns a Server configured with the given options. Files are
+// served from the current working directory unless WithRootDir or
+// WithBackend is given.
+func NewServer(options ...ServerOption) *Server {
+	s := newServer(options)
+	s.slots = newTransferSlots(s.config.maxTransfers, s.config.maxPerClient)
+	return s
+}
+
+// newServer returns a Server with the default configuration and options
+// applied.
+func newServer(options []ServerOption) *Server {
+	s := &Server{config: &serverConfig{
+		backend:         DirBackend("."),
+		maxWindowSize:   defaultMaxWindowSize,
+		rollover:        -1,
+		shutdownTimeout: defaultShutdownTimeout,
+		retransmit:      defaultRetransmit,
+		logger:          slog.Default(),
+	}}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// Reload replaces the configuration of the server with one built from
+// options, starting from the defaults like NewServer. Requests received
+// afterwards use the new configuration, transfers already running finish with
+// the one they started with. The current configuration is kept if the new
+// backend's root isn't a readable directory.
+func (s *Server) Reload(options ...ServerOption) error {
+	next := newServer(options).config
+	if err := next.checkBackend(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.config = next
+	s.mu.Unlock()
+	s.slots.setLimits(next.maxTransfers, next.maxPerClient)
+	return nil
+}
+
+// currentConfig returns the configuration new requests should use.
+func (s *Server) currentConfig() *serverConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// WithRootDir serves files from a directory on the local filesystem.
+func WithRootDir(dir string, options ...DirOption) ServerOption {
+	return WithBackend(DirBackend(dir, options...))
+}
+
+// WithBackend serves files from backend.
+func WithBackend(backend Backend) ServerOption {
+	return func(s *Server) {
+		s.config.backend = backend
+	}
+}
+
+// WithDisableCreate prevents clients from creating new files.
+func WithDisableCreate(s *Server) {
+	s.config.perms.DisableCreate = true
+}
+
+// WithDisableWrite makes the server read-only.
+func WithDisableWrite(s *Server) {
+	s.config.perms.DisableWrite = true
+}
+
+// WithAllowOverwrite allows clients to overwrite existing files.
+func WithAllowOverwrite(s *Server) {
+	s.config.perms.AllowOverwrite = true
+}
+
+// WithPathRule sets the permissions for a path and everything below it. The
+// rule with the longest matching prefix applies, paths not matching any rule
+// use the server-wide permissions.
+func WithPathRule(rule PathRule) ServerOption {
+	return func(s *Server) {
+		s.config.pathRules = s.config.pathRules.add(rule)
+	}
+}
+
+// WithReadHandler adds a handler generating the content of read requests.
+// Handlers are tried in the order they were added, files from the backend are
+// served if none of them handle the request.
+func WithReadHandler(handler ReadHandler) ServerOption {
+	return func(s *Server) {
+		s.config.readHandlers = append(s.config.readHandlers, handler)
+	}
+}
+
+// WithReadACL adds rules controlling which clients may read which paths.
+func WithReadACL(rules ...ACLRule) ServerOption {
+	return func(s *Server) {
+		s.config.readACL = append(s.config.readACL, rules...)
+	}
+}
+
+// WithWriteACL adds rules controlling which clients may write which paths.
+func WithWriteACL(rules ...ACLRule) ServerOption {
+	return func(s *Server) {
+		s.config.writeACL = append(s.config.writeACL, rules...)
+	}
+}
+
+// WithRateLimit limits each transfer to bytesPerSec.
+func WithRateLimit(bytesPerSec int64) ServerOption {
+	return func(s *Server) {
+		s.config.rateLimit = bytesPerSec
+	}
+}
+
+// WithGlobalRateLimit limits the combined throughput of all transfers to
+// bytesPerSec.
+func WithGlobalRateLimit(bytesPerSec int64) ServerOption {
+	return func(s *Server) {
+		s.config.globalLimiter = nil
+		if bytesPerSec > 0 {
+			s.config.globalLimiter = newRateLimiter(bytesPerSec)
+		}
+	}
+}
+
+// WithNetworkRateLimit limits the combined throughput of all transfers with
+// clients in network to bytesPerSec. Only the first network containing a client
+// applies.
+func WithNetworkRateLimit(network *net.IPNet, bytesPerSec int64) ServerOption {
+	return func(s *Server) {
+		if bytesPerSec <= 0 {
+			return
+		}
+		s.config.networkLimits = append(s.config.networkLimits, networkRateLimit{
+			network: network,
+			limiter: newRateLimiter(bytesPerSec),
+		})
+	}
+}
+
+// WithMaxTransfers limits the number of concurrent transfers. Requests over
+// the limit wait for the queue timeout and are then rejected.
+func WithMaxTransfers(max int) ServerOption {
+	return func(s *Server) {
+		s.config.maxTransfers = max
+	}
+}
+
+// WithMaxClientTransfers limits the number of concurrent transfers with a
+// single client IP address.
+func WithMaxClientTransfers(max int) ServerOption {
+	return func(s *Server) {
+		s.config.maxPerClient = max
+	}
+}
+
+// WithQueueTimeout sets how long a request over the transfer limits waits for
+// a transfer to finish before it's rejected. By default requests are rejected
+// immediately.
+func WithQueueTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.config.queueTimeout = timeout
+	}
+}
+
+// WithShutdownTimeout sets how long in-flight transfers may take to finish
+// once the server is shutting down before they're aborted.
+func WithShutdownTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.config.shutdownTimeout = timeout
+	}
+}
+
+// WithMetrics records statistics about requests and transfers in metrics.
+func WithMetrics(metrics *Metrics) ServerOption {
+	return func(s *Server) {
+		s.config.metrics = metrics
+	}
+}
+
+// WithHooks sets functions called when transfers start, make progress,
+// complete or fail.
+func WithHooks(hooks Hooks) ServerOption {
+	return func(s *Server) {
+		s.config.hooks = hooks
+	}
+}
+
+// WithAuditLog writes an AuditRecord as a line of JSON to w for every
+// request. Each record is written with a single call to Write.
+func WithAuditLog(w io.Writer) ServerOption {
+	return func(s *Server) {
+		s.config.audit = &auditLog{w: w}
+	}
+}
+
+// WithPortRange opens transfer sockets on a port from low to high inclusive
+// instead of any free port. Requests are rejected as busy when every port in
+// the range is in use.
+func WithPortRange(low, high int) ServerOption {
+	return func(s *Server) {
+		s.config.ports = portRange{low: low, high: high}
+	}
+}
+
+// WithSinglePort runs transfers over the socket requests arrive on instead of
+// a new socket each, for clients behind NAT or stateful firewalls that drop
+// replies from other ports. Packets are routed to transfers by the client's
+// address and port. The port range is not used.
+func WithSinglePort(s *Server) {
+	s.config.singlePort = true
+}
+
+// WithRetries sets how many times a packet is sent again after timeouts before
+// the transfer fails, 5 by default.
+func WithRetries(retries int) ServerOption {
+	return func(s *Server) {
+		s.config.retransmit.retries = retries
+	}
+}
+
+// WithAdaptiveTimeout derives the retransmission timeout of transfers from
+// their measured round trip time instead of using the fixed timeout. It's
+// doubled after every timeout up to the maximum set with WithMaxTimeout. A
+// timeout negotiated with the timeout option is always honoured.
+func WithAdaptiveTimeout(s *Server) {
+	s.config.retransmit.adaptive = true
+}
+
+// WithMaxTimeout sets the ceiling of the adaptive timeout, 30 seconds by
+// default.
+func WithMaxTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.config.retransmit.maxTimeout = timeout
+	}
+}
+
+// WithStrict rejects clients using the mail or unknown transfer modes instead
+// of treating them as octet mode.
+func WithStrict(s *Server) {
+	s.config.strict = true
+}
+
+// WithRFC1350 disables TFTP option extensions.
+func WithRFC1350(s *Server) {
+	s.config.rfc1350 = true
+}
+
+// WithMaxWindowSize sets the largest windowsize the server will agree to.
+// Requests for a larger window are acknowledged with this value. Sizes below 1
+// are ignored.
+func WithMaxWindowSize(size int) ServerOption {
+	return func(s *Server) {
+		if size < 1 {
+			return
+		}
+		s.config.maxWindowSize = size
+	}
+}
+
+// WithRollover sets the block number used after block 65535 when the client
+// doesn't negotiate it with the rollover option, either 0 or 1. By default the
+// server sends block 0 and accepts either from clients.
+func WithRollover(block int) ServerOption {
+	return func(s *Server) {
+		s.config.rollover = block
+	}
+}
+
+// WithLogger sets the logger, slog.Default() is used by default. Lines about
+// a request carry its transfer ID, peer, file and direction, and the options
+// once they're negotiated. Debug messages describe every packet.
+func WithLogger(logger *slog.Logger) ServerOption {
+	return func(s *Server) {
+		s.config.logger = logger
+	}
+}
+
+// ListenAndServe listens on the UDP address and serves requests until ctx is
+// cancelled, see Serve.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, conn)
+}
+
+// Serve serves requests arriving on conn until ctx is cancelled. Each transfer
+// is run on its own socket, or over conn in single port mode. Once ctx is
+// cancelled no new requests are accepted
+// and in-flight transfers get the shutdown timeout to finish before they're
+// aborted with an ERROR packet. Serve returns nil after a shutdown, otherwise
+// the error that stopped it. conn is closed when it returns.
+func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	defer conn.Close()
+
+	config := s.currentConfig()
+	if err := config.checkBackend(); err != nil {
+		return err
+	}
+
+	config.logger.Info("Starting TFTP server", "address", conn.LocalAddr().String(), "root", fmt.Sprint(config.backend))
+
+	// Transfers run under their own context so they can outlive ctx while
+	// draining
+	transferCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+	var transfers transferGroup
+
+	// The socket stays open while draining so single port transfers keep
+	// receiving packets, it's closed once they're done
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.drain(conn, &transfers, abort)
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	demux := newDemux(conn)
+	buffer := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			close(stop)
+			if ctx.Err() == nil {
+				abort()
+				return err
+			}
+			return nil
+		}
+		if n < 2 || demux.deliver(addr, buffer[:n]) || ctx.Err() != nil {
+			continue
+		}
+
+		// Requests are processed concurrently, copy it out of the buffer
+		req := append([]byte(nil), buffer[:n]...)
+
+		opcode := opCode(decodeUInt16(req[:2]))
+		reqFields := bytes.Split(req[2:], []byte{0})
+		reqFields = reqFields[:len(reqFields)-1] // Remove empty split
+
+		config := s.currentConfig()
+		reqConn := &requestConn{conn: conn, addr: addr, ctx: transferCtx, metrics: config.metrics}
+		switch opcode {
+		case opRead, opWrite:
+			// Dropped if draining has begun since ctx was checked
+			if !transfers.add() {
+				continue
+			}
+
+			// In single port mode the transfer is registered right away so
+			// retransmitted requests go to it instead of starting another
+			var transferConn *muxConn
+			if config.singlePort {
+				transferConn = demux.open(addr)
+			}
+
+			go func() {
+				defer transfers.done()
+				if transferConn == nil {
+					s.processRequest(config, reqConn, nil, ctx.Done(), opcode, reqFields)
+					return
+				}
+				defer transferConn.Close()
+				s.processRequest(config, reqConn, transferConn, ctx.Done(), opcode, reqFields)
+			}()
+		}
+	}
+}
+
+// drain waits for the transfers to finish within the shutdown timeout and
+// aborts them after it.
+func (s *Server) drain(conn net.PacketConn, transfers *transferGroup, abort func()) {
+	config := s.currentConfig()
+	config.logger.Info("Shutting down, waiting for transfers to finish", "address", conn.LocalAddr().String(), "timeout", config.shutdownTimeout)
+	transfers.stop()
+	drained := make(chan struct{})
+	go func() {
+		transfers.wait()
+		close(drained)
+	}()
+
+	timer := time.NewTimer(config.shutdownTimeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+	case <-timer.C:
+		config.logger.Warn("Shutdown timeout exceeded, aborting transfers", "address", conn.LocalAddr().String())
+		abort()
+		<-drained
+	}
+}
+
+// transferGroup counts the transfers started by Serve. Once it's stopped no
+// more transfers are added, so a request racing the shutdown can't start a
+// transfer after draining has begun.
+type transferGroup struct {
+	mu      sync.Mutex
+	stopped bool
+	running sync.WaitGroup
+}
+
+// add counts a new transfer, it reports false if the group was stopped.
+func (g *transferGroup) add() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return false
+	}
+	g.running.Add(1)
+	return true
+}
+
+func (g *transferGroup) done() {
+	g.running.Done()
+}
+
+func (g *transferGroup) stop() {
+	g.mu.Lock()
+	g.stopped = true
+	g.mu.Unlock()
+}
+
+// wait waits for the transfers to finish, the group must be stopped first.
+func (g *transferGroup) wait() {
+	g.running.Wait()
+}
+
+// checkBackend checks the root of the backend is a directory.
+func (c *serverConfig) checkBackend() error {
+	stat, err := c.backend.Stat(".")
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return errors.New("server root is not a directory")
+	}
+	return nil
+}
+
+// openRead opens the file for a read request. Read handlers are tried in
+// order before falling back to the backend. The size is -1 if it isn't known.
+func (c *serverConfig) openRead(req *ReadRequest) (io.ReadCloser, int64, error) {
+	for _, handler := range c.readHandlers {
+		r, size, err := handler(req)
+		if err != nil {
+			return nil, -1, err
+		}
+		if r == nil {
+			continue
+		}
+
+		if rc, ok := r.(io.ReadCloser); ok {
+			return rc, size, nil
+		}
+		return io.NopCloser(r), size, nil
+	}
+
+	stat, err := c.backend.Stat(req.Filename)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	file, err := c.backend.Open(req.Filename)
+	if err != nil {
+		return nil, -1, err
+	}
+	return file, stat.Size(), nil
+}
+
+// limiters returns the rate limits applying to a new transfer with addr.
+func (c *serverConfig) limiters(addr net.Addr) []*rateLimiter {
+	var limiters []*rateLimiter
+	if c.rateLimit > 0 {
+		limiters = append(limiters, newRateLimiter(c.rateLimit))
+	}
+
+	ip := addrIP(addr)
+	for _, limit := range c.networkLimits {
+		if limit.network.Contains(ip) {
+			limiters = append(limiters, limit.limiter)
+			break
+		}
+	}
+
+	if c.globalLimiter != nil {
+		limiters = append(limiters, c.globalLimiter)
+	}
+	return limiters
+}
+
+// processRequest answers a request and runs its transfer. transferConn is the
+// connection of a single port transfer, nil to open a transfer socket.
+// Requests waiting for a transfer slot are rejected once stopping is closed.
+func (s *Server) processRequest(config *serverConfig, conn *requestConn, transferConn net.PacketConn, stopping <-chan struct{}, op opCode, req [][]byte) {
+	record := &AuditRecord{
+		Time:    time.Now(),
+		Client:  conn.addr.String(),
+		Op:      strings.ToLower(op.String()),
+		Outcome: outcomeError,
+	}
+	defer func() {
+		config.metrics.request(op, record.Outcome)
+		record.Duration = time.Since(record.Time).Seconds()
+		if err := config.audit.write(record); err != nil {
+			config.logger.Error("Failed to write audit log", "error", err)
+		}
+	}()
+
+	if len(req) < 2 {
+		record.Outcome, record.Reason = outcomeInvalid, "Malformed request"
+		config.logger.Warn("Malformed request", "peer", conn.addr.String())
+		conn.sendError(errNotDefined, "")
+		return
+	}
+
+	filename := string(req[0])
+	mode := strings.ToLower(string(req[1])) // Modes are case insensitive
+
+	id := nextTransferID()
+	record.Transfer, record.Filename, record.Mode = id, filename, mode
+	record.RequestedOptions = rawOptions(req[2:])
+	conn.logger = config.logger.With(
+		"transfer", id,
+		"peer", conn.addr.String(),
+		"file", filename,
+		"direction", direction(op),
+	)
+	conn.log().Info("Request received", "op", op.String(), "mode", mode)
+	filepath, ok := cleanPath(filename)
+	if !ok {
+		conn.log().Warn("Path is not a file below the server root")
+		record.Outcome, record.Reason = outcomeDenied, "Path is not a file below the server root"
+		conn.sendError(errAccessViolation, "Access violation")
+		return
+	}
+
+	record.Path = filepath
+	if resolver, ok := config.backend.(pathResolver); ok {
+		record.Path = resolver.resolvePath(filepath)
+	}
+
+	perms := config.pathRules.permissions(filepath, config.perms)
+	if op == opWrite && perms.DisableWrite {
+		record.Outcome, record.Reason = outcomeDenied, "Writes disabled"
+		conn.log().Warn("Writes disabled")
+		conn.sendError(errAccessViolation, "Writes disabled")
+		return
+	}
+	if op == opRead && perms.DisableRead {
+		record.Outcome, record.Reason = outcomeDenied, "Reads disabled"
+		conn.log().Warn("Reads disabled")
+		conn.sendError(errAccessViolation, "Reads disabled")
+		return
+	}
+
+	acl := config.readACL
+	if op == opWrite {
+		acl = config.writeACL
+	}
+	if !acl.allows(addrIP(conn.addr), filepath) {
+		conn.log().Warn("Denied by ACL")
+		record.Outcome, record.Reason = outcomeDenied, "Denied by ACL"
+		conn.sendError(errAccessViolation, "Access denied")
+		return
+	}
+
+	client := addrIP(conn.addr).String()
+	if !s.slots.acquire(stopping, client, config.queueTimeout) {
+		select {
+		case <-stopping:
+			conn.log().Warn("Rejected, server shutting down")
+			record.Outcome, record.Reason = outcomeBusy, "Server shutting down"
+			conn.sendError(errNotDefined, "Server shutting down")
+		default:
+			conn.log().Warn("Rejected, too many transfers")
+			record.Outcome, record.Reason = outcomeBusy, "Too many transfers"
+			conn.sendError(errNotDefined, "Server busy, too many transfers")
+		}
+		return
+	}
+	defer s.slots.release(client)
+
+	if mode != ModeOctet && mode != ModeNetascii {
+		if config.strict {
+			record.Outcome, record.Reason = outcomeInvalid, "Unsupported mode"
+			conn.log().Warn("Unsupported mode", "mode", mode)
+			conn.sendError(errAccessViolation, "Unsupported mode")
+			return
+		}
+
+		conn.log().Warn("Unsupported mode, using octet instead", "mode", mode)
+		mode = ModeOctet
+	}
+
+	options, ackedOptions := parseOptions(req[2:])
+	if config.rfc1350 {
+		options, ackedOptions = defaultOptions.copy(), nil
+	}
+
+	if options.windowSize > config.maxWindowSize {
+		options.windowSize = config.maxWindowSize
+		if _, ok := ackedOptions[optionWindowSize]; ok {
+			ackedOptions[optionWindowSize] = strconv.Itoa(config.maxWindowSize)
+		}
+	}
+
+	var file io.Closer
+	var src io.ReadCloser
+	var dst io.WriteCloser
+	var err error
+
+	if op == opRead {
+		var size int64
+		src, size, err = config.openRead(&ReadRequest{
+			Filename: filepath,
+			Addr:     conn.addr,
+			Mode:     mode,
+			Options:  options.public(),
+		})
+		if errors.Is(err, fs.ErrNotExist) {
+			conn.sendError(errFileNotFound, "File not found")
+			conn.log().Info("File not found")
+			record.Outcome, record.Reason = outcomeNotFound, "File not found"
+			return
+		}
+
+		// tsize is -1 if the option wasn't given, size is -1 if it isn't known
+		if err == nil && options.tsize > -1 && size > -1 {
+			ackedOptions[optionTransferSize] = strconv.FormatInt(size, 10)
+		}
+		file = src
+	} else {
+		exists := config.backend.Exists(filepath)
+
+		if !exists && perms.DisableCreate {
+			record.Outcome, record.Reason = outcomeDenied, "Creating files disabled"
+			conn.log().Warn("Creating files disabled")
+			conn.sendError(errAccessViolation, "Cannot create new file")
+			return
+		}
+
+		if exists && !perms.AllowOverwrite {
+			conn.log().Warn("Attempted overwrite of existing file")
+			record.Outcome, record.Reason = outcomeExists, "File exists"
+			conn.sendError(errFileExists, "Attempted overwrite of existing file")
+			return
+		}
+
+		if options.tsize > -1 {
+			ackedOptions[optionTransferSize] = strconv.FormatInt(options.tsize, 10)
+		}
+		dst, err = config.backend.Create(filepath)
+		file = dst
+	}
+
+	if err != nil {
+		conn.log().Error("Failed to open file", "error", err)
+		record.Reason = err.Error()
+		conn.sendError(errAccessViolation, "Failed to open file")
+		return
+	}
+
+	// In single port mode the transfer runs over the server socket
+	newConn := transferConn
+	if newConn == nil {
+		newConn, err = listenTransfer(conn.conn.LocalAddr(), config.ports)
+		if errors.Is(err, errPortsExhausted) {
+			conn.log().Warn("Rejected, no free transfer port")
+			record.Outcome, record.Reason = outcomeBusy, "No free transfer port"
+			conn.sendError(errNotDefined, "Server busy, no free transfer port")
+			file.Close()
+			return
+		}
+		if err != nil {
+			conn.log().Error("Failed to open transfer socket", "error", err)
+			record.Reason = err.Error()
+			file.Close()
+			return
+		}
+	}
+
+	directConn := &requestConn{conn: newConn, addr: conn.addr, latched: true, logger: conn.logger, ctx: conn.ctx, metrics: config.metrics}
+	defer directConn.watch()()
+
+	// From here on the transfer has started as far as the client can tell
+	record.Outcome = outcomeFailed
+
+	rto := newRTO(config.retransmit, options)
+
+	// We need to send option ack
+	if !config.rfc1350 && len(ackedOptions) > 0 {
+		record.AckedOptions = ackedOptions
+		directConn.log().Debug("Sending OACK", "options", ackedOptions)
+		directConn.sendOAck(ackedOptions)
+		options.oackSent = true // Tells the transfer.recvFile() not to send an ack
+
+		if op == opRead { // Get client's ACK for our OACK
+			sent := time.Now()
+			deadline := sent.Add(rto.current())
+			for {
+				resp := directConn.readMessageBefore(opRead, defaultOptions, deadline)
+				if resp == nil && directConn.cancelled() {
+					directConn.sendError(errNotDefined, "Transfer cancelled")
+				}
+				if resp == nil || resp.op == opError {
+					record.Reason = "No ACK for OACK"
+					if resp != nil {
+						record.Reason = (&RemoteError{Code: resp.errorCode, Message: resp.errorMsg}).Error()
+					}
+					newConn.Close()
+					file.Close()
+					return
+				}
+
+				if resp.op == opRetransmit {
+					if !rto.expired() {
+						record.Reason = errMaxRetransmits.Error()
+						newConn.Close()
+						file.Close()
+						return
+					}
+
+					directConn.log().Debug("Retransmitting OACK", "timeout", rto.current())
+					directConn.sendOAck(ackedOptions)
+					config.metrics.retransmit()
+					deadline = time.Now().Add(rto.current())
+					continue
+				} else if resp.op == opAck && resp.blockID == 0 {
+					rto.answered(time.Since(sent))
+					break
+				} else if resp.op == opAck {
+					directConn.log().Debug("Ignoring stale ACK", "block", resp.blockID)
+					continue
+				} else {
+					directConn.log().Debug("Received illegal packet", "op", resp.op.String())
+					directConn.sendError(errIllegalOperation, "Invalid operation for read request")
+					record.Reason = fmt.Sprintf("unexpected %s packet", resp.op)
+					newConn.Close()
+					file.Close()
+					return
+				}
+			}
+		}
+	} else if config.rfc1350 {
+		directConn.log().Debug("TFTP options are disabled, not acknowledging")
+	}
+
+	if options.rollover < 0 {
+		options.rollover = config.rollover
+	}
+
+	request := TransferRead
+	if op == opWrite {
+		request = TransferWrite
+	}
+
+	t := &transfer{
+		id:           id,
+		op:           op,
+		request:      request,
+		conn:         directConn,
+		src:          src,
+		dst:          dst,
+		options:      options,
+		ackedOptions: ackedOptions,
+		remotePath:   filepath,
+		mode:         mode,
+		limiters:     config.limiters(conn.addr),
+		hooks:        config.hooks,
+		rto:          rto,
+	}
+
+	config.metrics.transferStarted(options)
+	start := time.Now()
+	if err := t.run(); err != nil {
+		record.Reason = err.Error()
+	} else {
+		record.Outcome = outcomeCompleted
+	}
+	record.Bytes = t.bytes
+	config.metrics.transferFinished(time.Since(start))
+	file.Close()
+}
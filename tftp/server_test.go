@@ -0,0 +1,480 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startTestServer(t *testing.T, options ...ServerOption) (string, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewServer(append([]ServerOption{WithRootDir(root), WithShutdownTimeout(0)}, options...)...)
+	go s.Serve(ctx, conn)
+	t.Cleanup(cancel)
+
+	return root, conn.LocalAddr().String()
+}
+
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+var roundTripTests = []struct {
+	name    string
+	size    int
+	options []ClientOption
+}{
+	{name: "empty", size: 0},
+	{name: "single block", size: 100},
+	{name: "exact block", size: defaultClientBlockSize * 2},
+	{name: "multi block", size: 100000},
+	{name: "rfc1350 exact block", size: 1024, options: []ClientOption{WithClientRFC1350}},
+	{name: "rfc1350 multi block", size: 10000, options: []ClientOption{WithClientRFC1350}},
+	{name: "small blocks", size: 5000, options: []ClientOption{WithClientBlockSize(8)}},
+	{name: "window", size: 100000, options: []ClientOption{WithClientWindowSize(8)}},
+	{name: "window exact", size: defaultClientBlockSize * 16, options: []ClientOption{WithClientWindowSize(8)}},
+	{name: "window partial", size: defaultClientBlockSize * 3, options: []ClientOption{WithClientWindowSize(8)}},
+	{name: "netascii", size: 100000, options: []ClientOption{WithClientMode(ModeNetascii)}},
+	{name: "netascii window", size: 100000, options: []ClientOption{WithClientMode(ModeNetascii), WithClientWindowSize(4)}},
+	{name: "rollover", size: 8 * 70000, options: []ClientOption{WithClientBlockSize(8), WithClientWindowSize(16)}},
+	{name: "rollover to 1", size: 8 * 70000, options: []ClientOption{WithClientBlockSize(8), WithClientWindowSize(16), WithClientRollover(1)}},
+	{name: "window over max", size: 500000, options: []ClientOption{WithClientWindowSize(1000)}},
+}
+
+func TestRoundTrip(t *testing.T) {
+	root, addr := startTestServer(t)
+
+	for _, test := range roundTripTests {
+		t.Run(test.name, func(t *testing.T) {
+			data := randomBytes(test.size)
+			client := NewClient(addr, test.options...)
+
+			if err := client.Put(test.name, bytes.NewReader(data)); err != nil {
+				t.Fatalf("put: %s", err)
+			}
+
+			stored, err := os.ReadFile(filepath.Join(root, test.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(stored, data) {
+				t.Fatalf("put: stored %d bytes, expected %d", len(stored), len(data))
+			}
+
+			var buf bytes.Buffer
+			if err := client.Get(test.name, &buf); err != nil {
+				t.Fatalf("get: %s", err)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Fatalf("get: received %d bytes, expected %d", buf.Len(), len(data))
+			}
+		})
+	}
+}
+
+func TestMaxWindowSizeInvalid(t *testing.T) {
+	_, addr := startTestServer(t, WithMaxWindowSize(0))
+
+	for i, options := range [][]ClientOption{{WithClientRFC1350}, {WithClientWindowSize(8)}} {
+		name := fmt.Sprint("file", i)
+		data := randomBytes(5000)
+		client := NewClient(addr, options...)
+		if err := client.Put(name, bytes.NewReader(data)); err != nil {
+			t.Fatalf("put: %s", err)
+		}
+		var buf bytes.Buffer
+		if err := client.Get(name, &buf); err != nil {
+			t.Fatalf("get: %s", err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("received %d bytes, expected %d", buf.Len(), len(data))
+		}
+	}
+}
+
+func TestGetNotFound(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	err := NewClient(addr).Get("missing", &bytes.Buffer{})
+	remoteErr, ok := err.(*RemoteError)
+	if !ok {
+		t.Fatalf("expected RemoteError, got %v", err)
+	}
+	if remoteErr.Code != uint16(errFileNotFound) {
+		t.Errorf("expected error code %d, got %d", errFileNotFound, remoteErr.Code)
+	}
+}
+
+func TestPutDisableWrite(t *testing.T) {
+	_, addr := startTestServer(t, WithDisableWrite)
+
+	err := NewClient(addr).Put("file", bytes.NewReader([]byte("data")))
+	remoteErr, ok := err.(*RemoteError)
+	if !ok {
+		t.Fatalf("expected RemoteError, got %v", err)
+	}
+	if remoteErr.Code != uint16(errAccessViolation) {
+		t.Errorf("expected error code %d, got %d", errAccessViolation, remoteErr.Code)
+	}
+}
+
+func TestServeIPv6(t *testing.T) {
+	conn, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %s", err)
+	}
+	root := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewServer(WithRootDir(root), WithShutdownTimeout(0)).Serve(ctx, conn)
+
+	data := randomBytes(5000)
+	client := NewClient(conn.LocalAddr().String())
+	if err := client.Put("file", bytes.NewReader(data)); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := client.Get("file", &buf); err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("received %d bytes, expected %d", buf.Len(), len(data))
+	}
+}
+
+// testProxy forwards packets between a client and the server at target,
+// delivering as many copies of each packet as copies returns. It counts the
+// DATA packets the server sends more than once.
+type testProxy struct {
+	front, back net.PacketConn
+	copies      func(packet []byte, toClient bool) int
+	mu          sync.Mutex
+	client      net.Addr
+	server      net.Addr
+	sent        map[string]bool // Source and block of DATA packets
+	resent      int
+}
+
+func startTestProxy(t *testing.T, target string, copies func(packet []byte, toClient bool) int) *testProxy {
+	t.Helper()
+
+	server, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProxy{copies: copies, server: server, sent: make(map[string]bool)}
+	if p.front, err = net.ListenPacket("udp4", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if p.back, err = net.ListenPacket("udp4", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		p.front.Close()
+		p.back.Close()
+	})
+
+	go p.forward(p.front, p.back, false, func(from net.Addr, packet []byte) net.Addr {
+		p.client = from
+		return p.server
+	})
+	go p.forward(p.back, p.front, true, func(from net.Addr, packet []byte) net.Addr {
+		p.server = from // The server's transfer ID
+		if opCode(decodeUInt16(packet[:2])) == opData {
+			key := fmt.Sprint(from, decodeUInt16(packet[2:4]))
+			if p.sent[key] {
+				p.resent++
+			}
+			p.sent[key] = true
+		}
+		return p.client
+	})
+	return p
+}
+
+func (p *testProxy) forward(from, to net.PacketConn, toClient bool, route func(net.Addr, []byte) net.Addr) {
+	buffer := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := from.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		dest := route(addr, buffer[:n])
+		copies := p.copies(buffer[:n], toClient)
+		p.mu.Unlock()
+		for i := 0; i < copies; i++ {
+			to.WriteTo(buffer[:n], dest)
+		}
+	}
+}
+
+func (p *testProxy) retransmits() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resent
+}
+
+var duplicatedPacketsTests = []struct {
+	name      string
+	options   []ClientOption
+	duplicate func(packet []byte, toClient bool) bool
+}{
+	{
+		name:      "rfc1350",
+		options:   []ClientOption{WithClientRFC1350},
+		duplicate: func([]byte, bool) bool { return true },
+	},
+	{
+		name:    "window acks",
+		options: []ClientOption{WithClientWindowSize(4)},
+		duplicate: func(packet []byte, toClient bool) bool {
+			return !toClient && opCode(decodeUInt16(packet[:2])) == opAck
+		},
+	},
+	{
+		name:    "window data",
+		options: []ClientOption{WithClientWindowSize(4)},
+		duplicate: func(packet []byte, toClient bool) bool {
+			return toClient && opCode(decodeUInt16(packet[:2])) == opData
+		},
+	},
+	{
+		name:      "window all",
+		options:   []ClientOption{WithClientWindowSize(4)},
+		duplicate: func([]byte, bool) bool { return true },
+	},
+}
+
+func TestDuplicatedPackets(t *testing.T) {
+	root, addr := startTestServer(t)
+	data := randomBytes(400 * 512)
+	if err := os.WriteFile(filepath.Join(root, "file"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range duplicatedPacketsTests {
+		t.Run(test.name, func(t *testing.T) {
+			proxy := startTestProxy(t, addr, func(packet []byte, toClient bool) int {
+				if test.duplicate(packet, toClient) {
+					return 2
+				}
+				return 1
+			})
+
+			var buf bytes.Buffer
+			if err := NewClient(proxy.front.LocalAddr().String(), test.options...).Get("file", &buf); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Fatalf("received %d bytes, expected %d", buf.Len(), len(data))
+			}
+
+			// Duplicates cause no retransmits, only timeouts do. A
+			// duplicated request starts a second transfer which the
+			// client refuses.
+			time.Sleep(50 * time.Millisecond)
+			if resent := proxy.retransmits(); resent != 0 {
+				t.Errorf("server sent %d blocks again", resent)
+			}
+		})
+	}
+}
+
+func TestLostOACK(t *testing.T) {
+	root, addr := startTestServer(t, WithAdaptiveTimeout)
+	dropped := false
+	proxy := startTestProxy(t, addr, func(packet []byte, toClient bool) int {
+		if toClient && !dropped && opCode(decodeUInt16(packet[:2])) == opOAck {
+			dropped = true
+			return 0
+		}
+		return 1
+	})
+
+	// The server must send the OACK again, not ACK 0 which would make the
+	// client fall back to 512 byte blocks
+	data := randomBytes(5000)
+	client := NewClient(proxy.front.LocalAddr().String(), WithClientBlockSize(1024))
+	if err := client.Put("file", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := os.ReadFile(filepath.Join(root, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Fatalf("stored %d bytes, expected %d", len(stored), len(data))
+	}
+}
+
+func TestServeShutdownAbortsTransfers(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewMemoryBackend()
+	backend.WriteFile("slow", randomBytes(100000))
+	s := NewServer(WithBackend(backend), WithRateLimit(10000), WithShutdownTimeout(100*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	go func() {
+		s.Serve(ctx, conn)
+		close(served)
+	}()
+
+	got := make(chan error, 1)
+	go func() {
+		got <- NewClient(conn.LocalAddr().String()).Get("slow", &bytes.Buffer{})
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return after the context was cancelled")
+	}
+
+	err = <-got
+	remoteErr, ok := err.(*RemoteError)
+	if !ok {
+		t.Fatalf("expected RemoteError, got %v", err)
+	}
+	if remoteErr.Code != uint16(errNotDefined) {
+		t.Errorf("expected error code %d, got %d", errNotDefined, remoteErr.Code)
+	}
+}
+
+func TestTransferGroupStop(t *testing.T) {
+	var g transferGroup
+	if !g.add() {
+		t.Fatal("expected transfer to be added")
+	}
+
+	g.stop()
+	if g.add() {
+		t.Error("expected no transfers to be added after stop")
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		g.wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("wait returned with a transfer running")
+	case <-time.After(20 * time.Millisecond):
+	}
+	g.done()
+	<-waited
+}
+
+func TestServerReload(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	s := NewServer(WithRootDir(root), WithDisableWrite, WithShutdownTimeout(0))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx, conn)
+
+	client := NewClient(conn.LocalAddr().String())
+	if err := client.Put("before", bytes.NewReader([]byte("data"))); err == nil {
+		t.Fatal("put allowed before reload")
+	}
+
+	if err := s.Reload(WithRootDir(filepath.Join(root, "missing"))); err == nil {
+		t.Error("reload with a missing root should fail")
+	}
+	if err := s.Reload(WithRootDir(root)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Put("after", bytes.NewReader([]byte("data"))); err != nil {
+		t.Errorf("put after reload: %s", err)
+	}
+}
+
+func TestServerLogAttributes(t *testing.T) {
+	var buf syncBuffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	root, addr := startTestServer(t, WithLogger(logger))
+	if err := os.WriteFile(filepath.Join(root, "file"), randomBytes(3000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewClient(addr, WithClientBlockSize(1024)).Get("file", &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond) // The server logs completion after the final ACK
+
+	var completed bool
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var record map[string]interface{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatal(err)
+		}
+		if record["msg"] == "Starting TFTP server" {
+			continue
+		}
+
+		if record["transfer"] == nil || record["peer"] == nil || record["file"] != "file" || record["direction"] != "send" {
+			t.Errorf("missing transfer attributes in %s", line)
+		}
+		if record["msg"] == "Transfer completed" {
+			completed = true
+			options, _ := record["options"].(map[string]interface{})
+			if options["blksize"] != float64(1024) {
+				t.Errorf("expected negotiated options in %s", line)
+			}
+		}
+	}
+	if !completed {
+		t.Errorf("no completed transfer logged in\n%s", buf.Bytes())
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
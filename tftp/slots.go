@@ -0,0 +1,94 @@
+package tftp
+
+import (
+	"sync"
+	"time"
+)
+
+// transferSlots bounds the number of concurrent transfers, in total and per
+// client IP. A limit of 0 is unlimited.
+type transferSlots struct {
+	mu        sync.Mutex
+	max       int
+	perClient int
+	active    int
+	clients   map[string]int
+	freed     chan struct{} // Closed and replaced when a slot is released
+}
+
+func newTransferSlots(max, perClient int) *transferSlots {
+	return &transferSlots{
+		max:       max,
+		perClient: perClient,
+		clients:   make(map[string]int),
+		freed:     make(chan struct{}),
+	}
+}
+
+// acquire takes a slot for client, waiting up to timeout for one to become
+// free or until done is closed, no slots are taken after that. It reports if a
+// slot was taken, which must be given back with release.
+func (s *transferSlots) acquire(done <-chan struct{}, client string, timeout time.Duration) bool {
+	var timer *time.Timer
+	for {
+		select {
+		case <-done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return false
+		default:
+		}
+
+		s.mu.Lock()
+		if (s.max == 0 || s.active < s.max) && (s.perClient == 0 || s.clients[client] < s.perClient) {
+			s.active++
+			s.clients[client]++
+			s.mu.Unlock()
+			if timer != nil {
+				timer.Stop()
+			}
+			return true
+		}
+		freed := s.freed
+		s.mu.Unlock()
+
+		if timeout <= 0 {
+			return false
+		}
+		if timer == nil {
+			timer = time.NewTimer(timeout)
+		}
+
+		select {
+		case <-freed:
+		case <-timer.C:
+			return false
+		case <-done:
+		}
+	}
+}
+
+// setLimits changes the limits. Transfers over a lowered limit keep their
+// slots, new transfers wait until enough of them are released.
+func (s *transferSlots) setLimits(max, perClient int) {
+	s.mu.Lock()
+	s.max = max
+	s.perClient = perClient
+	// Wake up waiting requests in case the limits were raised
+	close(s.freed)
+	s.freed = make(chan struct{})
+	s.mu.Unlock()
+}
+
+func (s *transferSlots) release(client string) {
+	s.mu.Lock()
+	s.active--
+	s.clients[client]--
+	if s.clients[client] <= 0 {
+		delete(s.clients, client)
+	}
+	close(s.freed)
+	s.freed = make(chan struct{})
+	s.mu.Unlock()
+}
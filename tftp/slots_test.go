@@ -0,0 +1,130 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTransferSlots(t *testing.T) {
+	s := newTransferSlots(3, 2)
+
+	if !s.acquire(nil, "a", 0) || !s.acquire(nil, "a", 0) {
+		t.Fatal("expected slots for client a")
+	}
+	if s.acquire(nil, "a", 0) {
+		t.Fatal("expected per client limit for a")
+	}
+	if !s.acquire(nil, "b", 0) {
+		t.Fatal("expected slot for client b")
+	}
+	if s.acquire(nil, "c", 0) {
+		t.Fatal("expected total limit")
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.release("a")
+	}()
+	if !s.acquire(nil, "c", time.Second) {
+		t.Fatal("expected slot for client c after release")
+	}
+	if s.acquire(nil, "c", 10*time.Millisecond) {
+		t.Fatal("expected total limit after timeout")
+	}
+}
+
+func TestServerMaxTransfers(t *testing.T) {
+	backend := NewMemoryBackend()
+	backend.WriteFile("slow", randomBytes(20000))
+	backend.WriteFile("fast", []byte("fast"))
+	_, addr := startTestServer(t, WithBackend(backend), WithRateLimit(50000), WithMaxTransfers(1))
+	client := NewClient(addr)
+
+	done := make(chan error)
+	go func() { done <- client.Get("slow", &bytes.Buffer{}) }()
+	time.Sleep(50 * time.Millisecond)
+
+	err := client.Get("fast", &bytes.Buffer{})
+	if remoteErr, ok := err.(*RemoteError); !ok || remoteErr.Code != uint16(errNotDefined) {
+		t.Errorf("expected server busy error, got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond) // The server finishes after the client's final ACK
+	if err := client.Get("fast", &bytes.Buffer{}); err != nil {
+		t.Errorf("expected transfer after slot was released, got %v", err)
+	}
+}
+
+func TestServerQueueTimeout(t *testing.T) {
+	backend := NewMemoryBackend()
+	backend.WriteFile("slow", randomBytes(20000))
+	_, addr := startTestServer(t, WithBackend(backend), WithRateLimit(50000),
+		WithMaxClientTransfers(1), WithQueueTimeout(2*time.Second))
+	client := NewClient(addr)
+
+	done := make(chan error)
+	go func() { done <- client.Get("slow", &bytes.Buffer{}) }()
+	time.Sleep(50 * time.Millisecond)
+
+	if err := client.Get("slow", &bytes.Buffer{}); err != nil {
+		t.Errorf("expected queued transfer to complete, got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerShutdownRejectsQueued(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewMemoryBackend()
+	backend.WriteFile("slow", randomBytes(20000))
+	s := NewServer(WithBackend(backend), WithRateLimit(50000), WithMaxTransfers(1),
+		WithQueueTimeout(5*time.Second), WithShutdownTimeout(5*time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan struct{})
+	go func() {
+		s.Serve(ctx, conn)
+		close(served)
+	}()
+	client := NewClient(conn.LocalAddr().String())
+
+	running := make(chan error, 1)
+	go func() { running <- client.Get("slow", &bytes.Buffer{}) }()
+	time.Sleep(50 * time.Millisecond)
+	queued := make(chan error, 1)
+	go func() { queued <- client.Get("slow", &bytes.Buffer{}) }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	// The queued request is refused instead of starting once the running
+	// transfer frees its slot
+	select {
+	case err := <-queued:
+		if remoteErr, ok := err.(*RemoteError); !ok || remoteErr.Code != uint16(errNotDefined) {
+			t.Errorf("expected shutdown error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued request wasn't rejected")
+	}
+
+	if err := <-running; err != nil {
+		t.Errorf("expected running transfer to finish, got %v", err)
+	}
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return after the context was cancelled")
+	}
+}
@@ -0,0 +1,501 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+var errMaxRetransmits = errors.New("max retransmits exceeded")
+
+// lastTransferID numbers transfers so their log lines can be told apart.
+var lastTransferID atomic.Uint64
+
+func nextTransferID() uint64 {
+	return lastTransferID.Add(1)
+}
+
+// direction describes a transfer from the local side, op is from the point of
+// view of the remote side like transfer.op.
+func direction(op opCode) string {
+	if op == opRead {
+		return "send"
+	}
+	return "receive"
+}
+
+type stater interface {
+	Stat() (os.FileInfo, error)
+}
+
+type lengther interface {
+	Len() int
+}
+
+// RemoteError is an ERROR packet received from the other side of a transfer.
+type RemoteError struct {
+	Code    uint16
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("tftp: remote error %d: %s", e.Code, e.Message)
+}
+
+// transfer drives a single file transfer over a requestConn. It's used by both
+// the server and the client, op is from the point of view of the remote side.
+type transfer struct {
+	id               uint64
+	op               opCode
+	request          TransferOp // The request that started the transfer, for hooks
+	blockCounter     uint64     // Absolute block number, wireBlock maps it to the packet field
+	lastSent         uint64     // Highest block sent, lower blocks sent again are retransmits
+	conn             *requestConn
+	src              io.Reader
+	dst              io.Writer
+	options          *tftpOptions
+	requestedOptions *tftpOptions
+	ackedOptions     map[string]string // The server's OACK for a write request, resent until data arrives
+	remotePath       string
+	mode             string
+	limiters         []*rateLimiter
+	hooks            Hooks
+	rto              *rto // Defaults to defaultRetransmit if nil
+	start            time.Time
+	bytes            int64 // File data that reached the receiver
+}
+
+type response struct {
+	op        opCode
+	blockID   uint16
+	errorCode uint16
+	errorMsg  string
+	data      []byte
+	options   *tftpOptions
+}
+
+func (t *transfer) run() error {
+	defer t.conn.Close()
+	defer t.conn.watch()()
+
+	t.blockCounter = 0
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	if t.rto == nil {
+		t.rto = newRTO(defaultRetransmit, t.options)
+	}
+	if t.requestedOptions == nil {
+		t.started()
+	}
+
+	var err error
+	switch t.op {
+	case opRead:
+		err = t.sendFile()
+	case opWrite:
+		err = t.recvFile()
+	}
+
+	if err != nil {
+		return t.failed(err)
+	}
+	t.conn.log().Info("Transfer completed", "duration", time.Since(t.start), "bytes", t.bytes)
+	if t.hooks.Complete != nil {
+		t.hooks.Complete(t.describe(nil))
+	}
+	return nil
+}
+
+// failed logs the transfer failing with err, calls the Fail hook and returns
+// err.
+func (t *transfer) failed(err error) error {
+	t.conn.log().Error("Transfer failed", "duration", time.Since(t.start), "bytes", t.bytes, "error", err)
+	if t.hooks.Fail != nil {
+		t.hooks.Fail(t.describe(err))
+	}
+	return err
+}
+
+// started is called once the options are final. It adds them to the
+// transfer's log lines and calls the Start hook.
+func (t *transfer) started() {
+	t.conn.logger = t.conn.log().With(t.options.logAttr())
+	if t.hooks.Start != nil {
+		t.hooks.Start(t.describe(nil))
+	}
+}
+
+func (t *transfer) progress() {
+	if t.hooks.Progress != nil {
+		t.hooks.Progress(t.describe(nil))
+	}
+}
+
+func (t *transfer) describe(err error) Transfer {
+	return Transfer{
+		ID:       t.id,
+		Peer:     t.conn.addr,
+		Filename: t.remotePath,
+		Op:       t.request,
+		Options:  t.options.public(),
+		Bytes:    t.bytes,
+		Duration: time.Since(t.start),
+		Err:      err,
+	}
+}
+
+// sendFile sends the source in windows of options.windowSize blocks. The
+// receiver acknowledges the last block of each window, or the last block it
+// received in order if there was a gap, and the next window starts from the
+// block after the acknowledged one. blockCounter is the last acknowledged
+// block.
+func (t *transfer) sendFile() error {
+	var size int64
+
+	if file, ok := t.src.(stater); ok {
+		stat, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		size = stat.Size()
+	} else if buf, ok := t.src.(lengther); ok {
+		size = int64(buf.Len())
+	}
+
+	t.conn.log().Info("Starting transfer", "size", size)
+	if t.mode == ModeNetascii {
+		t.src = newNetasciiReader(t.src)
+	}
+
+	window := make([][]byte, 0, t.options.windowSize)
+	var spare [][]byte
+	lastRead := false // The final, short, block is in the window
+
+	for {
+		for !lastRead && len(window) < t.options.windowSize {
+			var block []byte
+			if len(spare) > 0 {
+				block = spare[len(spare)-1]
+				spare = spare[:len(spare)-1]
+			} else {
+				block = make([]byte, t.options.blockSize)
+			}
+
+			block, err := t.readBlock(block)
+			if err != nil {
+				t.conn.sendError(errAccessViolation, "")
+				return err
+			}
+			window = append(window, block)
+			lastRead = len(block) < t.options.blockSize
+		}
+
+		for i, block := range window {
+			t.sendBlock(t.blockCounter+uint64(i)+1, block)
+		}
+
+		// Duplicate and delayed ACKs for blocks that were already acknowledged
+		// are ignored. Answering them with the window again would double the
+		// traffic every round (Sorcerer's Apprentice Syndrome), only a timeout
+		// retransmits.
+		sent := time.Now()
+		deadline := sent.Add(t.rto.current())
+		var resp *response
+		acked := 0
+		for acked == 0 {
+			resp = t.conn.readMessageBefore(t.op, t.options, deadline)
+			if resp != nil && resp.op == opOAck && t.blockCounter == 0 {
+				// The server sends its OACK again until the first block
+				// arrives, it answers the request like ACK 0 would
+				resp = &response{op: opAck, blockID: 0}
+			}
+			if resp == nil || resp.op != opAck {
+				break
+			}
+			if acked = t.ackedBlocks(resp.blockID, len(window)); acked <= 0 {
+				t.conn.log().Debug("Ignoring stale ACK", "block", resp.blockID)
+				acked = 0
+			}
+		}
+		if resp == nil {
+			return t.aborted()
+		}
+
+		if resp.op == opAck { // Client acknowledged data block
+			t.rto.answered(time.Since(sent))
+
+			for _, block := range window[:acked] {
+				t.bytes += int64(len(block))
+			}
+			spare = append(spare, window[:acked]...)
+			window = append(window[:0], window[acked:]...)
+			t.blockCounter += uint64(acked)
+			t.progress()
+
+			if lastRead && len(window) == 0 {
+				return nil
+			}
+		} else if resp.op == opError { // Client sent error
+			return &RemoteError{Code: resp.errorCode, Message: resp.errorMsg}
+		} else if resp.op == opRetransmit { // Read timed out
+			if !t.rto.expired() {
+				return errMaxRetransmits
+			}
+
+			t.conn.log().Debug("Retransmitting window", "block", t.wireBlock(t.blockCounter+1), "timeout", t.rto.current())
+			continue
+		} else {
+			t.conn.log().Debug("Received illegal packet", "op", resp.op.String())
+			t.conn.sendError(errIllegalOperation, "Invalid operation for read request")
+			return fmt.Errorf("unexpected %s packet", resp.op)
+		}
+	}
+}
+
+// recvFile writes received blocks to the destination. Every options.windowSize
+// in order blocks are acknowledged with a single ACK. A block ahead of the
+// expected one means part of the window was lost, the last in order block is
+// acknowledged so the sender restarts the window from there. Duplicates of
+// received blocks are dropped.
+func (t *transfer) recvFile() error {
+	t.blockCounter = 0
+
+	t.conn.log().Info("Starting transfer")
+	committer, _ := t.dst.(Committer)
+	var ascii *netasciiWriter
+	if t.mode == ModeNetascii {
+		ascii = newNetasciiWriter(t.dst)
+		t.dst = ascii
+	}
+
+	// The round trip is timed from the last ACK, or the request, to the first
+	// packet answering it
+	acked := t.start
+	timing := true
+	sendAck := func() {
+		t.conn.sendAck(t.wireBlock(t.blockCounter))
+		acked, timing = time.Now(), true
+	}
+
+	if !t.options.oackSent && t.requestedOptions == nil {
+		sendAck()
+	}
+	received := 0   // In order blocks received since the last ACK
+	unexpected := 0 // Out of order blocks received since the last in order block
+
+	for {
+		resp := t.conn.readMessageBefore(t.op, t.options, time.Now().Add(t.rto.current()))
+		if resp == nil {
+			return t.aborted()
+		}
+
+		if resp.op == opData || resp.op == opOAck {
+			var rtt time.Duration
+			if timing {
+				rtt, timing = time.Since(acked), false
+			}
+			t.rto.answered(rtt)
+		}
+
+		if resp.op == opData {
+			if !t.isNextBlock(resp.blockID) {
+				// Copies of blocks already received aren't a gap, answering
+				// them would make the sender resend blocks still in flight.
+				// A lost ACK is sent again when the read times out.
+				if t.isOldBlock(resp.blockID) {
+					t.conn.log().Debug("Ignoring duplicate block", "block", resp.blockID)
+					continue
+				}
+
+				// ACK the first block of a gap and then once per window so a
+				// sender retransmitting whole windows still gets an answer.
+				if unexpected%t.options.windowSize == 0 {
+					t.conn.log().Warn("Received unexpected block", "expected", t.wireBlock(t.blockCounter+1), "block", resp.blockID)
+					sendAck()
+				}
+				unexpected++
+				received = 0
+				continue
+			}
+
+			unexpected = 0
+			if t.requestedOptions != nil { // Options are final once data arrives
+				t.requestedOptions = nil
+				t.started()
+			}
+			last := len(resp.data) < t.options.blockSize
+			_, err := t.dst.Write(resp.data)
+			if err == nil && last && ascii != nil {
+				err = ascii.Flush()
+			}
+			if last {
+				if err := t.checkSize(t.bytes + int64(len(resp.data))); err != nil {
+					t.conn.sendError(errNotDefined, "Transfer size mismatch")
+					return err
+				}
+			}
+			if err == nil && last && committer != nil {
+				err = committer.Commit()
+			}
+			if err != nil {
+				t.conn.sendError(errAccessViolation, "Failed to write block")
+				return err
+			}
+
+			t.blockCounter++
+			received++
+			t.bytes += int64(len(resp.data))
+			t.conn.metrics.received(len(resp.data))
+			t.limit(len(resp.data) + 4)
+
+			if last || received == t.options.windowSize {
+				sendAck()
+				received = 0
+				t.progress()
+			}
+
+			if last { // Transfer complete
+				return nil
+			}
+		} else if resp.op == opError { // Client sent error
+			return &RemoteError{Code: resp.errorCode, Message: resp.errorMsg}
+		} else if resp.op == opRetransmit {
+			if !t.rto.expired() {
+				return errMaxRetransmits
+			}
+
+			if t.requestedOptions != nil {
+				t.conn.log().Debug("Retransmitting read request", "timeout", t.rto.current())
+				t.conn.sendReadRequest(t.remotePath, t.mode, t.requestedOptions.toMap())
+			} else if t.options.oackSent && t.blockCounter == 0 {
+				// The client may not have the OACK yet, an ACK 0 would tell it
+				// options weren't accepted
+				t.conn.log().Debug("Retransmitting OACK", "timeout", t.rto.current())
+				t.conn.sendOAck(t.ackedOptions)
+			} else {
+				t.conn.log().Debug("Retransmitting ACK", "block", t.wireBlock(t.blockCounter), "timeout", t.rto.current())
+				t.conn.sendAck(t.wireBlock(t.blockCounter))
+				received = 0
+			}
+			t.conn.metrics.retransmit()
+		} else if resp.op == opOAck {
+			if t.requestedOptions != nil {
+				t.options = resp.options
+				if t.options.rollover < 0 { // Server didn't acknowledge rollover
+					t.options.rollover = t.requestedOptions.rollover
+				}
+				t.rto.negotiated(t.options)
+			}
+			t.conn.log().Debug("ACKing OACK")
+			t.conn.sendAck(0)
+			acked, timing = time.Now(), true
+		} else {
+			t.conn.log().Debug("Received illegal packet", "op", resp.op.String())
+			t.conn.sendError(errIllegalOperation, "Invalid operation for write request")
+			return fmt.Errorf("unexpected %s packet", resp.op)
+		}
+	}
+}
+
+// checkSize returns an error if a size was given with the tsize option and
+// the transfer received a different number of bytes. Netascii transfers aren't
+// checked, their size on the wire differs from the file's.
+func (t *transfer) checkSize(received int64) error {
+	if t.options.tsize < 0 || t.mode == ModeNetascii || received == t.options.tsize {
+		return nil
+	}
+	return fmt.Errorf("received %d bytes, expected %d from tsize", received, t.options.tsize)
+}
+
+// readBlock fills block from the source. The returned slice is shorter than
+// the block size only for the last block of the transfer.
+func (t *transfer) readBlock(block []byte) ([]byte, error) {
+	n, err := io.ReadFull(t.src, block[:cap(block)])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return block[:n], nil
+}
+
+func (t *transfer) sendBlock(blockID uint64, block []byte) {
+	t.limit(len(block) + 4)
+	if blockID <= t.lastSent {
+		t.conn.metrics.retransmit()
+	} else {
+		t.lastSent = blockID
+	}
+	t.conn.metrics.sent(len(block))
+	t.conn.log().Debug("Sending DATA", "block", t.wireBlock(blockID))
+	t.conn.sendData(t.wireBlock(blockID), block)
+}
+
+// limit blocks until n bytes may be transferred under all rate limits. Sent
+// blocks, including retransmits, are limited before they're sent. Received
+// blocks are limited before they're acknowledged which slows the sender.
+func (t *transfer) limit(n int) {
+	for _, limiter := range t.limiters {
+		limiter.wait(t.conn.done(), n)
+	}
+}
+
+// aborted returns the error for a transfer ending without a response. If it
+// was cancelled the peer is told with an ERROR packet.
+func (t *transfer) aborted() error {
+	if t.conn.cancelled() {
+		t.conn.sendError(errNotDefined, "Transfer cancelled")
+		return t.conn.ctx.Err()
+	}
+	return errors.New("transfer aborted")
+}
+
+// wireBlock returns the 16 bit block number used on the wire for the absolute
+// block n. After block 65535 the block number rolls over to 0, or to 1 if
+// that was configured or negotiated with the rollover option.
+func (t *transfer) wireBlock(n uint64) uint16 {
+	if t.options.rollover == 1 && n > 0xffff {
+		return uint16((n-1)%0xffff + 1)
+	}
+	return uint16(n)
+}
+
+// isNextBlock reports if blockID is the block after blockCounter. If rollover
+// wasn't configured or negotiated, the first rollover decides whether the
+// peer rolls over to 0 or 1.
+func (t *transfer) isNextBlock(blockID uint16) bool {
+	if t.options.rollover < 0 && t.blockCounter+1 == 0x10000 {
+		switch blockID {
+		case 0, 1:
+			t.conn.log().Debug("Peer rolled over", "block", blockID)
+			t.options.rollover = int(blockID)
+			return true
+		}
+		return false
+	}
+	return blockID == t.wireBlock(t.blockCounter+1)
+}
+
+// isOldBlock reports if blockID is blockCounter or one of the window's worth
+// of blocks before it, a copy of a block that was already received.
+func (t *transfer) isOldBlock(blockID uint16) bool {
+	for i := uint64(0); i <= uint64(t.options.windowSize) && i <= t.blockCounter; i++ {
+		if t.wireBlock(t.blockCounter-i) == blockID {
+			return true
+		}
+	}
+	return false
+}
+
+// ackedBlocks returns how many of the unacknowledged blocks are acknowledged
+// by an ACK for blockID, or -1 if blockID isn't within the window.
+func (t *transfer) ackedBlocks(blockID uint16, window int) int {
+	for i := 0; i <= window; i++ {
+		if t.wireBlock(t.blockCounter+uint64(i)) == blockID {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,405 @@
+package tftp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// testPeer is the address scriptedConn's packets come from.
+var testPeer = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+
+// scriptedConn replays a list of packets from testPeer to the reader and
+// records every packet written. A nil packet is a timeout, as is a read once
+// the script is exhausted.
+type scriptedConn struct {
+	reads  [][]byte
+	writes [][]byte
+}
+
+func (c *scriptedConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if len(c.reads) == 0 || c.reads[0] == nil {
+		if len(c.reads) > 0 {
+			c.reads = c.reads[1:]
+		}
+		return 0, nil, timeoutError{}
+	}
+	n := copy(b, c.reads[0])
+	c.reads = c.reads[1:]
+	return n, testPeer, nil
+}
+
+func (c *scriptedConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.writes = append(c.writes, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (c *scriptedConn) Close() error                       { return nil }
+func (c *scriptedConn) LocalAddr() net.Addr                { return nil }
+func (c *scriptedConn) SetDeadline(t time.Time) error      { return nil }
+func (c *scriptedConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *scriptedConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// sent returns the opcode and block number of each written packet.
+func (c *scriptedConn) sent() [][2]uint16 {
+	sent := make([][2]uint16, len(c.writes))
+	for i, p := range c.writes {
+		sent[i] = [2]uint16{decodeUInt16(p[:2]), decodeUInt16(p[2:4])}
+	}
+	return sent
+}
+
+func dataPacket(blockID uint16, data []byte) []byte {
+	return append(append([]byte{0, byte(opData)}, encodeUInt16(blockID)...), data...)
+}
+
+func ackPacket(blockID uint16) []byte {
+	return append([]byte{0, byte(opAck)}, encodeUInt16(blockID)...)
+}
+
+func windowOptions(blockSize, windowSize int) *tftpOptions {
+	options := defaultOptions.copy()
+	options.blockSize = blockSize
+	options.windowSize = windowSize
+	options.oackSent = true
+	return options
+}
+
+func TestRecvWindowGap(t *testing.T) {
+	data := randomBytes(36)
+	block := func(n int) []byte {
+		end := n * 8
+		if end > len(data) {
+			end = len(data)
+		}
+		return data[(n-1)*8 : end]
+	}
+
+	conn := &scriptedConn{reads: [][]byte{
+		dataPacket(1, block(1)),
+		dataPacket(2, block(2)),
+		dataPacket(4, block(4)), // Block 3 was lost
+		dataPacket(3, block(3)),
+		dataPacket(4, block(4)),
+		dataPacket(5, block(5)),
+	}}
+
+	var buf bytes.Buffer
+	tr := &transfer{
+		op:      opWrite,
+		conn:    &requestConn{conn: conn, addr: testPeer},
+		dst:     &buf,
+		options: windowOptions(8, 4),
+	}
+
+	if err := tr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("expected %v, got %v", data, buf.Bytes())
+	}
+
+	expected := [][2]uint16{{uint16(opAck), 2}, {uint16(opAck), 5}}
+	if got := conn.sent(); !equalPackets(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestRecvTransferSizeMismatch(t *testing.T) {
+	conn := &scriptedConn{reads: [][]byte{
+		dataPacket(1, randomBytes(8)),
+		dataPacket(2, randomBytes(4)),
+	}}
+
+	backend := NewMemoryBackend()
+	dst, _ := backend.Create("file")
+	options := windowOptions(8, 1)
+	options.tsize = 20
+	tr := &transfer{
+		op:      opWrite,
+		conn:    &requestConn{conn: conn, addr: testPeer},
+		dst:     dst,
+		options: options,
+	}
+
+	if err := tr.run(); err == nil {
+		t.Fatal("expected the transfer to fail")
+	}
+	if backend.Exists("file") {
+		t.Error("upload shorter than its tsize was committed")
+	}
+	if sent := conn.sent(); sent[len(sent)-1][0] != uint16(opError) {
+		t.Errorf("expected an ERROR packet, got %v", sent)
+	}
+}
+
+func TestSendWindowGap(t *testing.T) {
+	conn := &scriptedConn{reads: [][]byte{
+		ackPacket(2), // Receiver lost block 3
+		ackPacket(6),
+	}}
+
+	tr := &transfer{
+		op:      opRead,
+		conn:    &requestConn{conn: conn, addr: testPeer},
+		src:     bytes.NewReader(randomBytes(40)),
+		options: windowOptions(8, 4),
+	}
+
+	if err := tr.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := uint16(opData)
+	expected := [][2]uint16{{d, 1}, {d, 2}, {d, 3}, {d, 4}, {d, 3}, {d, 4}, {d, 5}, {d, 6}}
+	if got := conn.sent(); !equalPackets(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestSendWindowFirstBlockLost(t *testing.T) {
+	conn := &scriptedConn{reads: [][]byte{
+		ackPacket(0), // Receiver lost block 1, or a duplicate, ignored
+		ackPacket(0),
+		nil, // Timeout, the window is sent again
+		ackPacket(4),
+		ackPacket(6),
+	}}
+
+	tr := &transfer{
+		op:      opRead,
+		conn:    &requestConn{conn: conn, addr: testPeer},
+		src:     bytes.NewReader(randomBytes(40)),
+		options: windowOptions(8, 4),
+	}
+
+	if err := tr.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := uint16(opData)
+	expected := [][2]uint16{{d, 1}, {d, 2}, {d, 3}, {d, 4}, {d, 1}, {d, 2}, {d, 3}, {d, 4}, {d, 5}, {d, 6}}
+	if got := conn.sent(); !equalPackets(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+var wireBlockTests = []struct {
+	rollover int
+	block    uint64
+	expected uint16
+}{
+	{rollover: -1, block: 0, expected: 0},
+	{rollover: -1, block: 65535, expected: 65535},
+	{rollover: -1, block: 65536, expected: 0},
+	{rollover: 0, block: 65537, expected: 1},
+	{rollover: 1, block: 0, expected: 0},
+	{rollover: 1, block: 65535, expected: 65535},
+	{rollover: 1, block: 65536, expected: 1},
+	{rollover: 1, block: 131070, expected: 65535},
+	{rollover: 1, block: 131071, expected: 1},
+}
+
+func TestSendIgnoresStaleAcks(t *testing.T) {
+	conn := &scriptedConn{reads: [][]byte{
+		ackPacket(1),
+		ackPacket(1), // Duplicated
+		ackPacket(2),
+		ackPacket(1), // Delayed
+		ackPacket(3),
+		ackPacket(2),
+		ackPacket(4),
+	}}
+
+	tr := &transfer{
+		op:      opRead,
+		conn:    &requestConn{conn: conn, addr: testPeer},
+		src:     bytes.NewReader(randomBytes(24)),
+		options: windowOptions(8, 1),
+	}
+
+	if err := tr.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := uint16(opData)
+	expected := [][2]uint16{{d, 1}, {d, 2}, {d, 3}, {d, 4}}
+	if got := conn.sent(); !equalPackets(got, expected) {
+		t.Errorf("expected every block sent once %v, got %v", expected, got)
+	}
+}
+
+func TestSendReorderedAcks(t *testing.T) {
+	conn := &scriptedConn{reads: [][]byte{
+		ackPacket(2), // Receiver lost block 3
+		ackPacket(1), // Overtaken by the ACK for block 2
+		ackPacket(6),
+		ackPacket(4), // Window ACK from a retransmit that wasn't needed
+		ackPacket(9),
+	}}
+
+	tr := &transfer{
+		op:      opRead,
+		conn:    &requestConn{conn: conn, addr: testPeer},
+		src:     bytes.NewReader(randomBytes(64)),
+		options: windowOptions(8, 4),
+	}
+
+	if err := tr.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := uint16(opData)
+	expected := [][2]uint16{{d, 1}, {d, 2}, {d, 3}, {d, 4}, {d, 3}, {d, 4}, {d, 5}, {d, 6}, {d, 7}, {d, 8}, {d, 9}}
+	if got := conn.sent(); !equalPackets(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestSendRetransmitsOnTimeout(t *testing.T) {
+	conn := &scriptedConn{reads: [][]byte{
+		ackPacket(1),
+		ackPacket(1), // Duplicate, not a reason to send block 2 again
+		nil,          // Timeout
+		ackPacket(2),
+	}}
+
+	tr := &transfer{
+		op:      opRead,
+		conn:    &requestConn{conn: conn, addr: testPeer},
+		src:     bytes.NewReader(randomBytes(12)),
+		options: windowOptions(8, 1),
+	}
+
+	if err := tr.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := uint16(opData)
+	expected := [][2]uint16{{d, 1}, {d, 2}, {d, 2}}
+	if got := conn.sent(); !equalPackets(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestRecvDuplicatedAndReorderedBlocks(t *testing.T) {
+	data := randomBytes(20)
+	conn := &scriptedConn{reads: [][]byte{
+		dataPacket(1, data[:8]),
+		dataPacket(1, data[:8]),  // Duplicated, dropped
+		dataPacket(3, data[16:]), // Overtook block 2, a gap
+		dataPacket(2, data[8:16]),
+		dataPacket(2, data[8:16]),
+		dataPacket(3, data[16:]),
+	}}
+
+	var buf bytes.Buffer
+	tr := &transfer{
+		op:      opWrite,
+		conn:    &requestConn{conn: conn, addr: testPeer},
+		dst:     &buf,
+		options: windowOptions(8, 1),
+	}
+
+	if err := tr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("expected %v, got %v", data, buf.Bytes())
+	}
+
+	a := uint16(opAck)
+	expected := [][2]uint16{{a, 1}, {a, 1}, {a, 2}, {a, 3}}
+	if got := conn.sent(); !equalPackets(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestRecvWindowDuplicates(t *testing.T) {
+	data := randomBytes(36)
+	block := func(n int) []byte {
+		end := n * 8
+		if end > len(data) {
+			end = len(data)
+		}
+		return data[(n-1)*8 : end]
+	}
+
+	conn := &scriptedConn{reads: [][]byte{
+		dataPacket(1, block(1)),
+		dataPacket(2, block(2)),
+		dataPacket(2, block(2)), // Duplicated mid-window
+		dataPacket(3, block(3)),
+		dataPacket(1, block(1)), // Delayed copy
+		dataPacket(4, block(4)),
+		dataPacket(4, block(4)), // Duplicated after the window ACK
+		dataPacket(5, block(5)),
+	}}
+
+	var buf bytes.Buffer
+	tr := &transfer{
+		op:      opWrite,
+		conn:    &requestConn{conn: conn, addr: testPeer},
+		dst:     &buf,
+		options: windowOptions(8, 4),
+	}
+
+	if err := tr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("expected %v, got %v", data, buf.Bytes())
+	}
+
+	expected := [][2]uint16{{uint16(opAck), 4}, {uint16(opAck), 5}}
+	if got := conn.sent(); !equalPackets(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestWireBlock(t *testing.T) {
+	for _, test := range wireBlockTests {
+		options := defaultOptions.copy()
+		options.rollover = test.rollover
+		tr := &transfer{options: options}
+
+		if got := tr.wireBlock(test.block); got != test.expected {
+			t.Errorf("rollover %d block %d: expected %d, got %d", test.rollover, test.block, test.expected, got)
+		}
+	}
+}
+
+func TestDetectRollover(t *testing.T) {
+	for _, next := range []uint16{0, 1} {
+		tr := &transfer{conn: &requestConn{}, options: defaultOptions.copy(), blockCounter: 65535}
+		if !tr.isNextBlock(next) {
+			t.Fatalf("block %d not accepted after 65535", next)
+		}
+		if tr.options.rollover != int(next) {
+			t.Errorf("expected rollover %d, got %d", next, tr.options.rollover)
+		}
+		tr.blockCounter++
+		if !tr.isNextBlock(next + 1) {
+			t.Errorf("block %d not accepted after rolling over to %d", next+1, next)
+		}
+	}
+}
+
+func equalPackets(a, b [][2]uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,8 +1,7 @@
-package main
+package tftp
 
 import (
-	"log"
-	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -40,7 +39,19 @@ func parseOptions(options [][]byte) (*tftpOptions, map[string]string) {
 		value := string(options[i+1])
 
 		switch option {
-		// case optionWindowSize: // windowsize isn't supported at this time
+		case optionWindowSize:
+			val, err := strconv.Atoi(value)
+			if err != nil {
+				continue
+			}
+
+			if val < 1 || val > 65535 { // Request value out of range
+				// Respond with default
+				ackedOptions[optionWindowSize] = strconv.Itoa(base.windowSize)
+				continue
+			}
+			base.windowSize = val
+			ackedOptions[optionWindowSize] = value
 		case optionBlockSize:
 			val, err := strconv.Atoi(value)
 			if err != nil {
@@ -63,9 +74,11 @@ func parseOptions(options [][]byte) (*tftpOptions, map[string]string) {
 			if val < 1 || val > 255 { // Request value out of range
 				// Response with default
 				ackedOptions[optionTimeout] = strconv.FormatInt(base.timeout.Nanoseconds()/int64(time.Second), 10)
+				base.timeoutSet = true
 				continue
 			}
 			base.timeout = time.Duration(val) * time.Second
+			base.timeoutSet = true
 			ackedOptions[optionTimeout] = value
 		case optionTransferSize:
 			val, err := strconv.ParseInt(value, 10, 64)
@@ -74,6 +87,12 @@ func parseOptions(options [][]byte) (*tftpOptions, map[string]string) {
 			}
 			// The calling function is responsible for fulfilling tsize
 			base.tsize = val
+		case optionRollover:
+			if value != "0" && value != "1" {
+				continue
+			}
+			base.rollover, _ = strconv.Atoi(value)
+			ackedOptions[optionRollover] = value
 		}
 	}
 
@@ -101,21 +120,15 @@ func encodeUInt16(in uint16) []byte {
 	return out
 }
 
-func fileExists(filename string) bool {
-	_, err := os.Stat(filename)
-	return err == nil
-}
-
-func debug(fmt string, a ...interface{}) {
-	if flgDebug {
-		log.Printf(fmt, a...)
-	}
-}
-
-func filesize(file *os.File) int64 {
-	stat, err := file.Stat()
-	if err != nil {
-		return -1
+// cleanPath returns the slash separated path of a requested filename relative
+// to the server root. Backslashes are treated as separators and leading
+// separators are ignored. False is returned if the path escapes the root or is
+// the root itself, which isn't a file.
+func cleanPath(filename string) (string, bool) {
+	name := strings.TrimLeft(strings.ReplaceAll(filename, "\\", "/"), "/")
+	name = path.Clean(name)
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return "", false
 	}
-	return stat.Size()
+	return name, true
 }
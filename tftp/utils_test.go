@@ -0,0 +1,32 @@
+package tftp
+
+import "testing"
+
+var cleanPathTests = []struct {
+	filename string
+	expected string
+	ok       bool
+}{
+	{filename: "file", expected: "file", ok: true},
+	{filename: "/boot/pxelinux.0", expected: "boot/pxelinux.0", ok: true},
+	{filename: "a..b.bin", expected: "a..b.bin", ok: true},
+	{filename: "dir/../file", expected: "file", ok: true},
+	{filename: "\\boot\\file", expected: "boot/file", ok: true},
+	{filename: "", ok: false},
+	{filename: "/", ok: false},
+	{filename: ".", ok: false},
+	{filename: "dir/..", ok: false},
+	{filename: "..", ok: false},
+	{filename: "../etc/passwd", ok: false},
+	{filename: "/dir/../../etc/passwd", ok: false},
+	{filename: "..\\secret", ok: false},
+}
+
+func TestCleanPath(t *testing.T) {
+	for _, test := range cleanPathTests {
+		got, ok := cleanPath(test.filename)
+		if ok != test.ok || got != test.expected {
+			t.Errorf("%q: expected %q %t, got %q %t", test.filename, test.expected, test.ok, got, ok)
+		}
+	}
+}